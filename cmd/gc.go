@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var gcRequest application.GCRequest
+
+// gcCmd deletes pool files that no index refers to
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete pool files that no Packages index of any suite refers to",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if gcRequest.GracePeriod < 0 {
+			return fmt.Errorf("--grace-period must not be negative")
+		}
+
+		app := newApplication(&config)
+		collected, err := app.GC(cmd.Context(), gcRequest)
+		action := "Deleted"
+		if gcRequest.DryRun {
+			action = "Would delete"
+		}
+
+		var size int64
+		for _, object := range collected {
+			size += object.Size
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s %s (%d bytes)\n", action, object.Key, object.Size)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s %d pool files, %d bytes\n", action, len(collected), size)
+		return nil
+	},
+}
+
+func init() {
+	gcCmd.Flags().DurationVar(&gcRequest.GracePeriod, "grace-period", 24*time.Hour, "Keep unreferenced pool files uploaded more recently than this")
+	gcCmd.Flags().BoolVar(&gcRequest.DryRun, "dry-run", false, "Report the pool files that would be deleted without deleting them")
+
+	rootCmd.AddCommand(gcCmd)
+}
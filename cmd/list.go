@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+)
+
+var listOutput string
+var listArchitecture string
+
+// listCmd prints the packages of the suite
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the packages published in the suite",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if listOutput != outputTable && listOutput != outputJSON && listOutput != outputCSV {
+			return fmt.Errorf("invalid output format %q. Allowed values are: table, json, csv", listOutput)
+		}
+
+		// Every component is listed unless --component is given
+		var filter application.PackageFilter
+		if cmd.Flags().Changed("component") {
+			filter.Component = config.Component
+		}
+		filter.Architecture = listArchitecture
+
+		app := newApplication(&config)
+		entries, err := app.List(cmd.Context(), filter)
+		if err != nil {
+			return err
+		}
+
+		return writePackages(cmd.OutOrStdout(), listOutput, entries)
+	},
+}
+
+func init() {
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", outputTable, "Output format (table, json, csv)")
+	listCmd.Flags().StringVar(&listArchitecture, "arch", "", "Only list the index of this architecture")
+
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+	"io"
+	"time"
+)
+
+var forceBreakLock bool
+
+// lockCmd groups the commands that inspect the suite lock
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect or break the lock that publish, remove, prune, reindex and gc take on the suite",
+}
+
+// lockStatusCmd prints the holder of the suite lock
+var lockStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show who holds the lock of the suite",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := newApplication(&config)
+		lock, err := app.LockStatus(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		if lock == nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "Not locked")
+			return nil
+		}
+		writeLock(cmd.OutOrStdout(), lock)
+		return nil
+	},
+}
+
+// lockBreakCmd deletes the suite lock
+var lockBreakCmd = &cobra.Command{
+	Use:   "break",
+	Short: "Delete a stale lock of the suite",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := newApplication(&config)
+		lock, err := app.BreakLock(cmd.Context(), forceBreakLock)
+		if errors.Is(err, application.ErrLocked) {
+			return fmt.Errorf("%w; pass --force to break a lock that is still held", err)
+		}
+		if errors.Is(err, application.ErrCorruptLock) {
+			return fmt.Errorf("%w; pass --force to delete it", err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if lock == nil {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "Not locked")
+			return nil
+		}
+		if lock.Corrupt {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "Deleted a corrupt lock")
+			return nil
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Broke the lock held by %s\n", lock)
+		return nil
+	},
+}
+
+// writeLock prints the fields of lock, one per line.
+func writeLock(w io.Writer, lock *application.Lock) {
+	state := "held"
+	if lock.Stale(time.Now()) {
+		state = "stale"
+	}
+
+	_, _ = fmt.Fprintf(w, "Owner:     %s\n", lock.Owner)
+	_, _ = fmt.Fprintf(w, "Hostname:  %s\n", lock.Hostname)
+	_, _ = fmt.Fprintf(w, "PID:       %d\n", lock.PID)
+	_, _ = fmt.Fprintf(w, "Acquired:  %s\n", lock.Acquired.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(w, "Heartbeat: %s\n", lock.Heartbeat.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(w, "Expires:   %s (%s)\n", lock.Expires.Format(time.RFC3339), state)
+}
+
+func init() {
+	lockBreakCmd.Flags().BoolVar(&forceBreakLock, "force", false, "Break the lock even if its lease has not expired")
+
+	lockCmd.AddCommand(lockStatusCmd, lockBreakCmd)
+	rootCmd.AddCommand(lockCmd)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Output formats supported by list
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+// packageRow is a row of the list output.
+type packageRow struct {
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	Component    string `json:"component"`
+	Size         int64  `json:"size"`
+	Filename     string `json:"filename"`
+}
+
+var packageColumns = []string{"package", "version", "architecture", "component", "size", "filename"}
+
+func newPackageRow(entry application.PackageEntry) packageRow {
+	// A missing or malformed Size is reported as zero
+	size, _ := strconv.ParseInt(entry.Record.Paragraph.Get("Size"), 10, 64)
+	return packageRow{
+		Package:      entry.Record.Key.PackageName,
+		Version:      entry.Record.Key.Version,
+		Architecture: entry.Record.Key.Architecture,
+		Component:    entry.Component,
+		Size:         size,
+		Filename:     entry.Record.Paragraph.Get("Filename"),
+	}
+}
+
+func (r packageRow) values() []string {
+	return []string{r.Package, r.Version, r.Architecture, r.Component, strconv.FormatInt(r.Size, 10), r.Filename}
+}
+
+// writePackages writes entries to w in the given output format.
+func writePackages(w io.Writer, format string, entries []application.PackageEntry) error {
+	rows := make([]packageRow, 0, len(entries))
+	for _, entry := range entries {
+		rows = append(rows, newPackageRow(entry))
+	}
+
+	switch format {
+	case outputTable:
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "PACKAGE\tVERSION\tARCHITECTURE\tCOMPONENT\tSIZE")
+		for _, row := range rows {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\n", row.Package, row.Version, row.Architecture, row.Component, row.Size)
+		}
+		return writer.Flush()
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case outputCSV:
+		writer := csv.NewWriter(w)
+		_ = writer.Write(packageColumns)
+		for _, row := range rows {
+			_ = writer.Write(row.values())
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("invalid output format %q. Allowed values are: table, json, csv", format)
+	}
+}
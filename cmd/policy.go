@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// policyCmd groups the commands that manage the policy stored in the repository
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Show or change the policy that publish, remove, prune and reindex enforce on every run, and the stored retention policies",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		// The --allowed-* flags are the policy to store rather than a restriction of this run
+		return validateConfig(&config, application.Policy{})
+	},
+}
+
+// policyShowCmd prints the stored policy
+var policyShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the policy stored in the repository",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := newApplication(&config)
+		policy, err := app.StoredPolicy(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		writePolicy(cmd.OutOrStdout(), policy)
+		return nil
+	},
+}
+
+// policySetCmd stores the --allowed-* and retention flags given as the policy of the repository
+var policySetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Store the --allowed-* flags given, and --keep-versions and --max-age for --archive and --component, in the repository policy",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		setRetention := flags.Changed("keep-versions") || flags.Changed("max-age")
+		if !flags.Changed("allowed-suites") && !flags.Changed("allowed-components") && !flags.Changed("allowed-architectures") && !setRetention {
+			return fmt.Errorf("nothing to set: pass --allowed-suites, --allowed-components, --allowed-architectures, --keep-versions or --max-age")
+		}
+
+		app := newApplication(&config)
+		policy, err := app.UpdatePolicy(cmd.Context(), func(policy *application.RepositoryPolicy) {
+			if flags.Changed("allowed-suites") {
+				policy.Suites = config.AllowedSuites
+			}
+			if flags.Changed("allowed-components") {
+				policy.Components = config.AllowedComponents
+			}
+			if flags.Changed("allowed-architectures") {
+				policy.Architectures = config.AllowedArchitectures
+			}
+			// Both values are stored together, so a flag not given keeps no limit
+			if setRetention {
+				policy.SetComponentRetention(config.Archive, config.Component, application.Retention{
+					KeepVersions: config.KeepVersions,
+					MaxAge:       config.MaxAge,
+				})
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		writePolicy(cmd.OutOrStdout(), policy)
+		return nil
+	},
+}
+
+// policyClearCmd deletes the stored policy
+var policyClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete the policy stored in the repository",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := newApplication(&config)
+		if err := app.ClearPolicy(cmd.Context()); err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), "Deleted the repository policy")
+		return nil
+	},
+}
+
+// writePolicy prints the allowed values of policy, one kind per line, followed by
+// the retention policy of each component.
+func writePolicy(w io.Writer, policy application.RepositoryPolicy) {
+	_, _ = fmt.Fprintf(w, "Suites:        %s\n", allowedValues(policy.Suites))
+	_, _ = fmt.Fprintf(w, "Components:    %s\n", allowedValues(policy.Components))
+	_, _ = fmt.Fprintf(w, "Architectures: %s\n", allowedValues(policy.Architectures))
+
+	components := slices.Sorted(maps.Keys(policy.Retention))
+	for _, component := range components {
+		_, _ = fmt.Fprintf(w, "Retention:     %s: %s\n", component, policy.Retention[component])
+	}
+}
+
+// allowedValues formats a list of allowed values, where an empty list allows any.
+func allowedValues(values []string) string {
+	if len(values) == 0 {
+		return "any"
+	}
+	return strings.Join(values, ", ")
+}
+
+func init() {
+	policyCmd.AddCommand(policyShowCmd, policySetCmd, policyClearCmd)
+	rootCmd.AddCommand(policyCmd)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+)
+
+var pruneDeletePool bool
+
+// pruneCmd applies the retention policy to the Packages indices of a component
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Drop old package versions from the repository indices according to --keep-versions and --max-age, or the stored retention policy",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := newApplication(&config)
+		pruned, err := app.Prune(cmd.Context(), pruneDeletePool)
+		if errors.Is(err, application.ErrNoRetention) {
+			return fmt.Errorf("%w; pass --keep-versions or --max-age, or store them with aptforge policy set", err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(pruned) == 0 {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "Nothing to prune")
+			return nil
+		}
+		for _, key := range pruned {
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Pruned %s\n", key)
+		}
+		return nil
+	},
+}
+
+func init() {
+	pruneCmd.Flags().BoolVar(&pruneDeletePool, "delete-pool", false, "Delete the pool files of pruned packages that no index of another suite refers to")
+
+	rootCmd.AddCommand(pruneCmd)
+}
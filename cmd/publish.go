@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+)
+
+var validConflictPolicies = map[string]struct{}{
+	"replace": {},
+	"skip":    {},
+	"fail":    {},
+}
+
+// publishCmd uploads a .deb and adds it to the repository
+var publishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "Upload .deb packages and update the repository metadata",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Validate conflict policy
+		if _, valid := validConflictPolicies[config.OnConflict]; !valid {
+			return fmt.Errorf("invalid conflict policy. Allowed values are: replace, skip, fail")
+		}
+
+		filePaths, err := application.ExpandDebPaths(config.FilePaths)
+		if err != nil {
+			return err
+		}
+
+		app := newApplication(&config)
+		if err := app.Publish(cmd.Context(), filePaths); err != nil {
+			return err
+		}
+
+		logger.Infof("%d files uploaded successfully to %s", len(filePaths), config.Bucket)
+		return nil
+	},
+}
+
+func init() {
+	publishCmd.Flags().StringArrayVar(&config.FilePaths, "file", nil, "Path to a .deb file, a glob pattern or a directory of .deb files to upload; repeatable")
+	publishCmd.Flags().IntVar(&config.Concurrency, "concurrency", 4, "Number of .deb files uploaded to the pool in parallel")
+	publishCmd.Flags().StringVar(&config.Architecture, "arch", "", "Target architecture; must match the package and defaults to its Architecture field (e.g., amd64, armhf, riscv64)")
+	publishCmd.Flags().StringVar(&config.OnConflict, "on-conflict", "replace", "What to do when the same package version is already published (replace, skip, fail)")
+	_ = publishCmd.MarkFlagRequired("file")
+
+	rootCmd.AddCommand(publishCmd)
+}
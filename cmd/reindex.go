@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var reindexRequest application.ReindexRequest
+
+// reindexCmd regenerates the indices and Release files of the suite
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Regenerate the Packages variants and the Release files of the suite from its Packages indices or the pool",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		request := reindexRequest
+		if request.WholePool && !request.FromPool {
+			return fmt.Errorf("--whole-pool requires --from-pool")
+		}
+		if request.FromPool && !cmd.Flags().Changed("cache") {
+			request.CachePath = defaultPoolCachePath(config.Bucket)
+		}
+
+		app := newApplication(&config)
+		return app.Reindex(cmd.Context(), request)
+	},
+}
+
+// defaultPoolCachePath returns the pool cache of bucket in the user cache directory,
+// or no cache when there is none.
+func defaultPoolCachePath(bucket string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		logger.WithError(err).Warn("No user cache directory; rebuilding without a pool cache")
+		return ""
+	}
+	return filepath.Join(cacheDir, "aptforge", bucket+".json")
+}
+
+func init() {
+	reindexCmd.Flags().BoolVar(&reindexRequest.FromPool, "from-pool", false, "Rebuild the Packages indices of the component from the .deb files below pool/<component>/ they refer to")
+	reindexCmd.Flags().BoolVar(&reindexRequest.WholePool, "whole-pool", false, "With --from-pool, index every .deb below pool/<component>/, including packages of other suites and versions removed or pruned from this one")
+	reindexCmd.Flags().StringVar(&reindexRequest.CachePath, "cache", "", "Local file caching the stanzas of pool files for --from-pool (default <user cache dir>/aptforge/<bucket>.json; empty disables it)")
+
+	rootCmd.AddCommand(reindexCmd)
+}
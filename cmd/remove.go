@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+var removeRequest application.RemoveRequest
+
+// removeCmd drops a package from the Packages indices of a component
+var removeCmd = &cobra.Command{
+	Use:   "remove <package>[<relation><version>]",
+	Short: "Remove a package, or the versions of it matching a constraint such as <<2.0, from the repository indices",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		removeRequest.PackageName, removeRequest.Relation, removeRequest.Version, err = parseVersionConstraint(args[0])
+		if err != nil {
+			return err
+		}
+
+		// Architecture: all stanzas are selected with --arch all
+		if removeRequest.Architecture != "" && removeRequest.Architecture != deb.ArchitectureAll {
+			if err := config.Policy().CheckArchitecture(removeRequest.Architecture); err != nil {
+				return fmt.Errorf("invalid architecture: %w", err)
+			}
+		}
+
+		app := newApplication(&config)
+		removed, err := app.Remove(cmd.Context(), removeRequest)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range removed {
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Removed %s\n", key)
+		}
+		return nil
+	},
+}
+
+// parsePackageArgument splits a <package>[=<version>] argument.
+func parsePackageArgument(argument string) (string, string) {
+	packageName, version, _ := strings.Cut(argument, "=")
+	return packageName, version
+}
+
+// parseVersionConstraint splits a <package>[<relation><version>] argument, where the
+// relation is one of <<, <=, =, >= and >>, as in a Depends field.
+func parseVersionConstraint(argument string) (string, deb.Relation, string, error) {
+	i := strings.IndexAny(argument, "<=>")
+	if i < 0 {
+		return argument, "", "", nil
+	}
+
+	packageName := strings.TrimSpace(argument[:i])
+	relation, version, ok := deb.CutRelation(argument[i:])
+	version = strings.TrimSpace(version)
+	if !ok || version == "" || strings.ContainsAny(version, "<=>") {
+		return "", "", "", fmt.Errorf("invalid version constraint %q: use <package><relation><version> with <<, <=, =, >= or >>", argument)
+	}
+	return packageName, relation, version, nil
+}
+
+func init() {
+	removeCmd.Flags().StringVar(&removeRequest.Architecture, "arch", "", "Only remove from the index of this architecture; \"all\" selects Architecture: all packages")
+	removeCmd.Flags().BoolVar(&removeRequest.DeletePool, "delete-pool", false, "Delete the pool files of removed packages that no index of another suite refers to")
+
+	rootCmd.AddCommand(removeCmd)
+}
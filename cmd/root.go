@@ -2,109 +2,315 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/signer"
+	"github.com/pavliha/aptforge/internal/storage"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"time"
 )
 
 const Description string = "AptForge is an open-source command-line tool for managing custom APT repositories, designed to streamline the upload of .deb packages and automate the generation of \nrepository metadata files such as Packages and Release."
 
-// Valid values for Architecture, Archive, and Component
-var validArchitectures = map[string]struct{}{
-	"amd64": {},
-	"arm64": {},
-	"i386":  {},
-}
+// Config holds the values parsed from command-line flags and environment variables.
+type Config struct {
+	FilePaths     []string
+	Concurrency   int
+	Bucket        string
+	AccessKey     string
+	SecretKey     string
+	Endpoint      string
+	Component     string
+	Origin        string
+	Label         string
+	Architecture  string
+	Architectures []string
+	Components    []string
+	Archive       string
+	Secure        bool
+	OnConflict    string
+	GPGKey        string
+	GPGPassphrase string
+	AllowUnsigned bool
 
-var validArchives = map[string]struct{}{
-	"stable":   {},
-	"testing":  {},
-	"unstable": {},
-}
+	// Suite lock taken by the commands that change indices
+	LockOwner string
+	LockTTL   time.Duration
+	LockWait  time.Duration
 
-var validComponents = map[string]struct{}{
-	"main":     {},
-	"contrib":  {},
-	"non-free": {},
-}
+	// Whether the storage supports If-Match and If-None-Match writes
+	ConditionalWrites bool
 
-// Config holds the values parsed from command-line flags and environment variables.
-type Config struct {
-	FilePath     string
-	Bucket       string
-	AccessKey    string
-	SecretKey    string
-	Endpoint     string
-	Component    string
-	Origin       string
-	Label        string
-	Architecture string
-	Archive      string
-	Secure       bool
+	// Generations of each index kept below by-hash/
+	ByHashGenerations int
+
+	// Retention policy of the component, applied after publish and by prune; the
+	// stored one applies when neither is set
+	KeepVersions int
+	MaxAge       time.Duration
+
+	// Packages variants written for every index, by compression name
+	Compressions []string
+
+	// Date of the Release files in RFC 3339 format, for reproducible output
+	Date string
+
+	// Optional repository policy restricting the accepted names
+	AllowedArchitectures []string
+	AllowedSuites        []string
+	AllowedComponents    []string
 }
 
 var config Config
 
+// logger is shared by every command
+var logger = log.New()
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "aptforge",
 	Short: Description,
 	Long:  Description,
-	Run: func(cmd *cobra.Command, args []string) {
-		// Your main application logic goes here
-		log.Infof("Configuration: %v", config)
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Arguments have been parsed; errors from here on are not usage errors
+		cmd.SilenceUsage = true
+		return validateConfig(&config, config.Policy())
 	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
-func Execute() (*Config, error) {
-	err := rootCmd.Execute()
-	if err != nil {
-		return nil, err
+func Execute() error {
+	return rootCmd.Execute()
+}
+
+// validateConfig checks the flags shared by every command against policy and falls
+// back to environment variables for the credentials and the GPG passphrase.
+func validateConfig(config *Config, policy application.Policy) error {
+	// Fallback to environment variables for an access key and secret key
+	if config.AccessKey == "" {
+		config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if config.SecretKey == "" {
+		config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	// Fallback to environment variables for the GPG passphrase
+	if config.GPGPassphrase == "" {
+		config.GPGPassphrase = os.Getenv("APTFORGE_GPG_PASSPHRASE")
+	}
+
+	if config.KeepVersions < 0 || config.MaxAge < 0 {
+		return fmt.Errorf("--keep-versions and --max-age must not be negative")
+	}
+	if config.LockTTL < application.MinLockTTL {
+		return fmt.Errorf("--lock-ttl must be at least %s", application.MinLockTTL)
+	}
+	if config.LockWait < 0 {
+		return fmt.Errorf("--lock-wait must not be negative")
+	}
+	if config.ByHashGenerations < application.MinByHashGenerations {
+		return fmt.Errorf("--by-hash-generations must be at least %d", application.MinByHashGenerations)
 	}
 
 	// Validate required inputs
-	if config.FilePath == "" || config.Bucket == "" || config.Endpoint == "" {
-		return nil, fmt.Errorf("missing required arguments: file, bucket, endpoint")
+	if config.Bucket == "" || config.Endpoint == "" {
+		return fmt.Errorf("missing required arguments: bucket, endpoint")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return fmt.Errorf("missing credentials: pass --access-key and --secret-key or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
 	}
 
-	// Validate Architecture
-	if _, valid := validArchitectures[config.Architecture]; !valid {
-		return nil, fmt.Errorf("invalid architecture. Allowed values are: amd64, arm64, i386")
+	if err := validateNames(config, policy); err != nil {
+		return err
+	}
+	if _, err := compressionSuffixes(config.Compressions); err != nil {
+		return err
 	}
+	_, err := releaseDate(config)
+	return err
+}
 
-	// Validate Archive
-	if _, valid := validArchives[config.Archive]; !valid {
-		return nil, fmt.Errorf("invalid archive. Allowed values are: stable, testing, unstable")
+// releaseDate returns the date written to Release files: --date, or else
+// SOURCE_DATE_EPOCH. The zero time stands for the current time.
+func releaseDate(config *Config) (time.Time, error) {
+	if config.Date != "" {
+		date, err := time.Parse(time.RFC3339, config.Date)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --date: %w", err)
+		}
+		return date, nil
 	}
 
-	// Validate Component
-	if _, valid := validComponents[config.Component]; !valid {
-		return nil, fmt.Errorf("invalid component. Allowed values are: main, contrib, non-free")
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %w", err)
+		}
+		return time.Unix(seconds, 0), nil
 	}
 
-	return &config, nil
+	return time.Time{}, nil
+}
+
+// compressionSuffixes returns the file suffixes of the named index compressions.
+func compressionSuffixes(names []string) ([]string, error) {
+	suffixes := make([]string, 0, len(names))
+	for _, name := range names {
+		suffix, err := deb.IndexCompressionSuffix(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --compressions: %w", err)
+		}
+		suffixes = append(suffixes, suffix)
+	}
+	return suffixes, nil
+}
+
+// newApplication creates the application for the parsed configuration.
+func newApplication(config *Config) application.Application {
+	logger.SetLevel(log.DebugLevel)
+
+	// The GPG key is read from --gpg-key, or passed as armored content in APTFORGE_GPG_KEY
+	var signing *signer.Config
+	if config.GPGKey != "" || os.Getenv("APTFORGE_GPG_KEY") != "" {
+		signing = &signer.Config{
+			KeyFile:    config.GPGKey,
+			Key:        os.Getenv("APTFORGE_GPG_KEY"),
+			Passphrase: config.GPGPassphrase,
+		}
+	}
+
+	// The compressions and the date were checked by validateConfig
+	compressions, _ := compressionSuffixes(config.Compressions)
+	date, _ := releaseDate(config)
+
+	return application.New(logger.WithField("pkg", "application"), &application.Config{
+		Storage: &storage.Config{
+			Endpoint:  config.Endpoint,
+			AccessKey: config.AccessKey,
+			SecretKey: config.SecretKey,
+			Bucket:    config.Bucket,
+			Secure:    config.Secure,
+		},
+		Signing:       signing,
+		Component:     config.Component,
+		Origin:        config.Origin,
+		Label:         config.Label,
+		Architecture:  config.Architecture,
+		Architectures: config.Architectures,
+		Components:    config.Components,
+		Archive:       config.Archive,
+		OnConflict:    application.ConflictPolicy(config.OnConflict),
+		Policy:        config.Policy(),
+		Concurrency:   config.Concurrency,
+		LockOwner:     config.LockOwner,
+		LockTTL:       config.LockTTL,
+		LockWait:      config.LockWait,
+
+		NoConditionalWrites: !config.ConditionalWrites,
+		ByHashGenerations:   config.ByHashGenerations,
+		Compressions:        compressions,
+		Date:                date,
+		AllowUnsigned:       config.AllowUnsigned,
+		Retention: application.Retention{
+			KeepVersions: config.KeepVersions,
+			MaxAge:       config.MaxAge,
+		},
+	})
+}
+
+// Policy returns the policy of this run configured by the --allowed-* flags.
+func (c *Config) Policy() application.Policy {
+	return application.Policy{
+		Suites:        c.AllowedSuites,
+		Components:    c.AllowedComponents,
+		Architectures: c.AllowedArchitectures,
+	}
+}
+
+// validateNames checks the suite, component and architecture flags against the
+// Debian naming rules, the dpkg architecture table and policy.
+func validateNames(config *Config, policy application.Policy) error {
+	if err := policy.CheckSuite(config.Archive); err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	if err := policy.CheckComponent(config.Component); err != nil {
+		return fmt.Errorf("invalid component: %w", err)
+	}
+	// The architecture is taken from the package when not set
+	if config.Architecture != "" {
+		if err := policy.CheckArchitecture(config.Architecture); err != nil {
+			return fmt.Errorf("invalid architecture: %w", err)
+		}
+	}
+
+	for _, component := range config.Components {
+		if err := policy.CheckComponent(component); err != nil {
+			return fmt.Errorf("invalid suite component: %w", err)
+		}
+	}
+	for _, architecture := range config.Architectures {
+		if err := policy.CheckArchitecture(architecture); err != nil {
+			return fmt.Errorf("invalid suite architecture: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// defaultLockOwner returns the user running AptForge.
+func defaultLockOwner() string {
+	if owner := os.Getenv("USER"); owner != "" {
+		return owner
+	}
+	return "aptforge"
 }
 
 func init() {
-	// File upload flags
-	rootCmd.Flags().StringVar(&config.FilePath, "file", "", "Path to the file to upload")
-	rootCmd.Flags().StringVar(&config.Bucket, "bucket", "", "Name of the S3 bucket")
-	rootCmd.Flags().StringVar(&config.AccessKey, "access-key", "", "Access Key")
-	rootCmd.Flags().StringVar(&config.SecretKey, "secret-key", "", "Secret Access Key")
-	rootCmd.Flags().StringVar(&config.Endpoint, "endpoint", "s3.amazonaws.com", "S3-compatible endpoint (e.g., fra1.digitaloceanspaces.com)")
-
-	// Release file metadata flags
-	rootCmd.Flags().StringVar(&config.Component, "component", "main", "Component of the APT repository (e.g., main, contrib, non-free)")
-	rootCmd.Flags().StringVar(&config.Origin, "origin", "Apt Repository", "Origin of the APT repository")
-	rootCmd.Flags().StringVar(&config.Label, "label", "Apt Repo", "Label for the APT repository")
-	rootCmd.Flags().StringVar(&config.Architecture, "arch", "amd64", "Target architecture for the repository (e.g., amd64, arm64, i386)")
-	rootCmd.Flags().StringVar(&config.Archive, "archive", "stable", "Archive type of the APT repository (e.g., stable, testing, unstable)")
-	rootCmd.Flags().BoolVar(&config.Secure, "secure", true, "Enable secure connections")
+	flags := rootCmd.PersistentFlags()
+
+	// Storage flags
+	flags.StringVar(&config.Bucket, "bucket", "", "Name of the S3 bucket")
+	flags.StringVar(&config.AccessKey, "access-key", "", "Access Key (default: AWS_ACCESS_KEY_ID)")
+	flags.StringVar(&config.SecretKey, "secret-key", "", "Secret Access Key (default: AWS_SECRET_ACCESS_KEY)")
+	flags.StringVar(&config.Endpoint, "endpoint", "s3.amazonaws.com", "S3-compatible endpoint (e.g., fra1.digitaloceanspaces.com)")
+	flags.BoolVar(&config.Secure, "secure", true, "Enable secure connections")
+
+	// Repository identity and Release file metadata flags
+	flags.StringVar(&config.Archive, "archive", "stable", "Suite or codename of the APT repository (e.g., stable, bookworm, jammy)")
+	flags.StringVar(&config.Component, "component", "main", "Component of the APT repository (e.g., main, contrib, non-free, nightly)")
+	flags.StringVar(&config.Origin, "origin", "Apt Repository", "Origin of the APT repository")
+	flags.StringVar(&config.Label, "label", "Apt Repo", "Label for the APT repository")
+	flags.StringSliceVar(&config.Architectures, "architectures", nil, "Architectures of the suite; discovered from the repository when not set. Architecture: all packages are added to each of them")
+	flags.StringSliceVar(&config.Components, "components", nil, "Components of the suite; discovered from the repository when not set")
+
+	// Repository policy flags
+	flags.StringSliceVar(&config.AllowedArchitectures, "allowed-architectures", nil, "Restrict the architectures this run accepts; policy set stores them in the repository (default: any dpkg architecture)")
+	flags.StringSliceVar(&config.AllowedSuites, "allowed-suites", nil, "Restrict the suites this run accepts; policy set stores them in the repository (default: any valid suite name)")
+	flags.StringSliceVar(&config.AllowedComponents, "allowed-components", nil, "Restrict the components this run accepts; policy set stores them in the repository (default: any valid component name)")
+
+	// Release signing flags
+	flags.StringVar(&config.GPGKey, "gpg-key", "", "Path to an armored GPG private key used to sign Release files")
+	flags.StringVar(&config.GPGPassphrase, "gpg-passphrase", "", "Passphrase of the GPG private key")
+	flags.BoolVar(&config.AllowUnsigned, "allow-unsigned", false, "Change a signed suite without a GPG key, deleting its InRelease and Release.gpg")
+
+	flags.StringSliceVar(&config.Compressions, "compressions", []string{"none", "gz"}, "Packages variants written for every index: none, gz, xz, bz2, zst")
+	flags.StringVar(&config.Date, "date", "", "Date written to Release files in RFC 3339 format, for reproducible output (default: SOURCE_DATE_EPOCH or the current time)")
+	flags.IntVar(&config.ByHashGenerations, "by-hash-generations", 3, "Generations of each index kept below by-hash/ before they are pruned (at least 2)")
+
+	// Retention policy flags
+	flags.IntVar(&config.KeepVersions, "keep-versions", 0, "Keep only the newest N versions of each package of the component (default: the stored retention policy, or keep all)")
+	flags.DurationVar(&config.MaxAge, "max-age", 0, "Drop versions whose pool file is older than this, except the newest of each package (e.g., 720h)")
+
+	// Suite lock flags
+	flags.StringVar(&config.LockOwner, "lock-owner", defaultLockOwner(), "Owner recorded in the suite lock (e.g., a CI job URL)")
+	flags.DurationVar(&config.LockTTL, "lock-ttl", 2*time.Minute, "Lease of the suite lock; a lock without a heartbeat for this long is stale")
+	flags.DurationVar(&config.LockWait, "lock-wait", 30*time.Second, "How long to wait for a suite locked by another process")
+	flags.BoolVar(&config.ConditionalWrites, "conditional-writes", true, "Write indices and the lock with If-Match; disable for storage that does not support conditional writes")
 
 	// Mark required flags
-	_ = rootCmd.MarkFlagRequired("file")
-	_ = rootCmd.MarkFlagRequired("bucket")
-	_ = rootCmd.MarkFlagRequired("access-key")
-	_ = rootCmd.MarkFlagRequired("secret-key")
+	_ = rootCmd.MarkPersistentFlagRequired("bucket")
 }
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pavliha/aptforge/internal/application"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+// showCmd prints the Packages stanzas of a package
+var showCmd = &cobra.Command{
+	Use:   "show <package>[=<version>]",
+	Short: "Show the full Packages stanza of every published version of a package",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var filter application.PackageFilter
+		filter.PackageName, filter.Version = parsePackageArgument(args[0])
+		if cmd.Flags().Changed("component") {
+			filter.Component = config.Component
+		}
+
+		app := newApplication(&config)
+		entries, err := app.Show(cmd.Context(), filter)
+		if err != nil {
+			return err
+		}
+
+		for i, entry := range entries {
+			if i > 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStdout())
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "# %s\n", filepath.Join(entry.Component, "binary-"+entry.Architecture))
+			_, _ = fmt.Fprint(cmd.OutOrStdout(), entry.Record.Paragraph.String())
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(showCmd)
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd checks the suite for inconsistencies
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the Release files, indices and pool of the suite agree",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := newApplication(&config)
+		problems, err := app.Verify(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems", len(problems))
+		}
+
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), "Repository is consistent")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
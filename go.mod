@@ -3,21 +3,25 @@ module github.com/pavliha/aptforge
 go 1.23.0
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.6
 	github.com/blakesmith/ar v0.0.0-20190502131153-809d4375e1fb
+	github.com/dsnet/compress v0.0.1
+	github.com/klauspost/compress v1.17.9
 	github.com/minio/minio-go/v7 v7.0.76
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.1
 	github.com/stretchr/testify v1.9.0
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
+	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/go-ini/ini v1.67.0 // indirect
 	github.com/goccy/go-json v0.10.3 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/klauspost/compress v1.17.9 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
 	github.com/minio/md5-simd v1.1.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
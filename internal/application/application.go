@@ -3,32 +3,121 @@ package application
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"github.com/pavliha/aptforge/internal/deb"
 	"github.com/pavliha/aptforge/internal/filereader"
+	"github.com/pavliha/aptforge/internal/signer"
 	"github.com/pavliha/aptforge/internal/storage"
 	log "github.com/sirupsen/logrus"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 )
 
+// ConflictPolicy decides what happens when a package with the same name, version
+// and architecture is already present in the Packages index.
+type ConflictPolicy string
+
+const (
+	// ConflictReplace replaces the existing stanza with the new one.
+	ConflictReplace ConflictPolicy = "replace"
+	// ConflictSkip keeps the existing stanza and leaves the index untouched.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictFail rejects the package when its checksum differs from the existing one.
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ErrPackageConflict is returned under ConflictFail when a package is re-published with different content.
+var ErrPackageConflict = errors.New("package already exists with a different checksum")
+
+// ErrArchitectureMismatch is returned when the package's Architecture field does not match the requested architecture.
+var ErrArchitectureMismatch = errors.New("package architecture does not match the target architecture")
+
+// ErrSignedSuite is returned when the Release file of a signed suite would be
+// rewritten without a signing key.
+var ErrSignedSuite = errors.New("suite is signed but no signing key is configured")
+
+// errInvalidPackagesIndex is returned when a stored Packages file cannot be parsed.
+var errInvalidPackagesIndex = errors.New("invalid Packages index")
+
+// maxPackagesUpdateAttempts bounds the read-modify-write cycles of a Packages index
+// that loses the race against another writer.
+const maxPackagesUpdateAttempts = 5
+
 type Config struct {
-	Storage      *storage.Config
-	Archive      string
-	Component    string
-	Origin       string
-	Label        string
+	Storage   *storage.Config
+	Signing   *signer.Config
+	Archive   string
+	Component string
+	Origin    string
+	Label     string
+	// Architecture is the architecture the package is published to. When empty it is
+	// taken from the package's Architecture field.
 	Architecture string
+	// Architectures and Components override the architectures and components of the
+	// suite. When empty they are discovered from the indices below dists/<suite>/.
+	// Architecture: all packages are added to the Packages index of every suite architecture.
+	Architectures []string
+	Components    []string
+	OnConflict    ConflictPolicy
+	// Policy restricts the names this run accepts. The policy stored in the repository
+	// is enforced as well.
+	Policy Policy
+	// Concurrency is the number of pool uploads run in parallel by Publish. Values
+	// below one upload one file at a time.
+	Concurrency int
+	// LockOwner is recorded in the suite lock taken by Publish, Remove, Prune, Reindex
+	// and GC.
+	LockOwner string
+	// LockTTL is the lease of the suite lock, renewed while it is held. It is at least
+	// MinLockTTL.
+	LockTTL time.Duration
+	// LockWait is how long to wait for a suite locked by another process.
+	LockWait time.Duration
+	// NoConditionalWrites is set for storage that does not support If-Match and
+	// If-None-Match. Indices and the suite lock are then written unconditionally,
+	// and the lock is only held once it reads back as written after lockSettleDelay.
+	NoConditionalWrites bool
+	// ByHashGenerations is the number of generations of each index kept below by-hash/.
+	ByHashGenerations int
+	// Date is written to the Date field of Release files instead of the current time,
+	// so that the same packages give byte-identical metadata.
+	Date time.Time
+	// Retention is applied to the indices of Component after each publish and by Prune.
+	// When it drops nothing, the retention policy stored for Component is applied.
+	Retention Retention
+	// Compressions are the file suffixes of the Packages variants written for every
+	// index, "" standing for the uncompressed file, as returned by
+	// deb.IndexCompressionSuffix. When empty, Packages and Packages.gz are written.
+	Compressions []string
+	// AllowUnsigned lets the Release file of a suite that has an InRelease file be
+	// rewritten without a signing key, which deletes its signatures.
+	AllowUnsigned bool
 }
 
 type Application interface {
+	Publish(ctx context.Context, filePaths []string) error
+	Remove(ctx context.Context, request RemoveRequest) ([]deb.PackageKey, error)
+	List(ctx context.Context, filter PackageFilter) ([]PackageEntry, error)
+	Show(ctx context.Context, filter PackageFilter) ([]PackageEntry, error)
+	Reindex(ctx context.Context, request ReindexRequest) error
+	Prune(ctx context.Context, deletePool bool) ([]deb.PackageKey, error)
+	GC(ctx context.Context, request GCRequest) ([]storage.ObjectInfo, error)
+	Verify(ctx context.Context) ([]Problem, error)
+	LockStatus(ctx context.Context) (*Lock, error)
+	BreakLock(ctx context.Context, force bool) (*Lock, error)
+	StoredPolicy(ctx context.Context) (RepositoryPolicy, error)
+	UpdatePolicy(ctx context.Context, update func(policy *RepositoryPolicy)) (RepositoryPolicy, error)
+	ClearPolicy(ctx context.Context) error
+
 	LoadDebFile(filePath string) (filereader.File, error)
 	CloseFile(file filereader.File)
 	ExtractDebMetadata(file filereader.File) (*deb.PackageMetadata, error)
+	TargetArchitectures(ctx context.Context, metadata *deb.PackageMetadata) ([]string, error)
 	UploadDebFile(ctx context.Context, metadata *deb.PackageMetadata, file filereader.File) error
-	UpdatePackagesFile(ctx context.Context, packagesPath string, metadata *deb.PackageMetadata) (*bytes.Buffer, *bytes.Buffer, error)
-	UploadPackageReleaseFile(ctx context.Context, releasePath string, packagesBuffer, packagesGzBuffer *bytes.Buffer) error
 	UploadSuiteReleaseFile(ctx context.Context, suiteReleasePath string, architectures, components []string) error
 }
 
@@ -37,17 +126,25 @@ type applicationImpl struct {
 	storage    storage.Storage
 	fileReader filereader.Reader
 	extractor  deb.Extractor
+	signer     signer.Signer
 	config     *Config
 }
 
 func New(logger *log.Entry, config *Config) Application {
-	return &applicationImpl{
+	app := &applicationImpl{
 		logger:     logger,
 		config:     config,
 		storage:    storage.Initialize(logger, config.Storage),
 		fileReader: filereader.New(logger.WithField("pkg", "file")),
 		extractor:  deb.New(logger.WithField("pkg", "deb")),
 	}
+
+	// Release files are only signed when a signing key is configured
+	if config.Signing != nil {
+		app.signer = signer.Initialize(logger.WithField("pkg", "signer"), config.Signing)
+	}
+
+	return app
 }
 
 func (a *applicationImpl) LoadDebFile(filePath string) (filereader.File, error) {
@@ -70,141 +167,376 @@ func (a *applicationImpl) CloseFile(file filereader.File) {
 	}
 }
 
+// ExtractDebMetadata validates the .deb structure and reads its control metadata.
+// The package name and version must follow the Debian rules, as they make up the
+// pool path of the package.
 func (a *applicationImpl) ExtractDebMetadata(file filereader.File) (*deb.PackageMetadata, error) {
+	// Reject malformed or truncated archives before anything is uploaded
+	if err := a.extractor.Validate(file); err != nil {
+		return nil, fmt.Errorf("invalid .deb file: %w", err)
+	}
+
 	metadata, err := a.extractor.ExtractPackageMetadata(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract metadata: %w", err)
 	}
 
+	if err := deb.ValidatePackageName(metadata.PackageName); err != nil {
+		return nil, fmt.Errorf("invalid .deb file: %w", err)
+	}
+	if _, err := deb.ParseVersion(metadata.Version); err != nil {
+		return nil, fmt.Errorf("invalid .deb file: %w", err)
+	}
+
 	return metadata, nil
 }
 
+// TargetArchitectures returns the architectures whose Packages index the package is
+// added to. It is the package's own architecture, or every suite architecture for
+// Architecture: all packages. A configured architecture that does not match the
+// package is rejected so that a package never lands in another architecture's index,
+// and every target architecture must be allowed by the repository policy.
+func (a *applicationImpl) TargetArchitectures(ctx context.Context, metadata *deb.PackageMetadata) ([]string, error) {
+	if metadata.Architecture != deb.ArchitectureAll {
+		if a.config.Architecture != "" && a.config.Architecture != metadata.Architecture {
+			return nil, fmt.Errorf("%w: package is %s, target is %s", ErrArchitectureMismatch, metadata.Architecture, a.config.Architecture)
+		}
+		if err := a.config.Policy.CheckArchitecture(metadata.Architecture); err != nil {
+			return nil, fmt.Errorf("invalid package architecture: %w", err)
+		}
+		return []string{metadata.Architecture}, nil
+	}
+
+	architectures := slices.Clone(a.config.Architectures)
+	if len(architectures) == 0 {
+		indices, err := a.listSuiteIndices(ctx, a.suiteDir())
+		if err != nil {
+			return nil, err
+		}
+		_, architectures = suiteLayout(indices)
+	}
+	if a.config.Architecture != "" {
+		architectures = appendMissing(architectures, a.config.Architecture)
+	}
+	if len(architectures) == 0 {
+		return nil, fmt.Errorf("package is Architecture: %s but the suite has no architectures yet; pass the architectures explicitly", deb.ArchitectureAll)
+	}
+	for _, architecture := range architectures {
+		if err := a.config.Policy.CheckArchitecture(architecture); err != nil {
+			return nil, fmt.Errorf("invalid suite architecture: %w", err)
+		}
+	}
+
+	a.logger.Debugf("Publishing Architecture: %s package to %s", deb.ArchitectureAll, strings.Join(architectures, ", "))
+	return architectures, nil
+}
+
+// UploadDebFile uploads the .deb to the pool and records its pool path, size and
+// checksums in metadata. The checksums are computed while the file is streamed.
 func (a *applicationImpl) UploadDebFile(ctx context.Context, metadata *deb.PackageMetadata, file filereader.File) error {
 	debPath := deb.GeneratePoolPath(a.config.Component, metadata)
-	err := a.storage.UploadFile(ctx, debPath, file)
+
+	hashing := newHashingFile(file)
+	err := a.storage.UploadFile(ctx, debPath, hashing)
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
+
+	metadata.Filename = debPath
+	metadata.Checksums = hashing.Sum()
+	a.logger.Debugf("Uploaded %s (%d bytes, sha256 %s)", debPath, metadata.Checksums.Size, metadata.Checksums.SHA256)
 	return nil
 }
 
-func (a *applicationImpl) UpdatePackagesFile(ctx context.Context, packagesPath string, metadata *deb.PackageMetadata) (*bytes.Buffer, *bytes.Buffer, error) {
-	// Download an existing Packages file
-	existingPackagesBuffer, err := a.downloadPackagesFromStorage(ctx, packagesPath)
+// checkConflict applies the conflict policy to a package against the stanzas of an index.
+func (a *applicationImpl) checkConflict(records []deb.PackageRecord, metadata *deb.PackageMetadata, file filereader.File) (bool, error) {
+	if a.config.OnConflict == "" || a.config.OnConflict == ConflictReplace {
+		return true, nil
+	}
+
+	key := metadataKey(metadata)
+	for _, record := range records {
+		if record.Key != key {
+			continue
+		}
+
+		// Only hash the local file when there is something to compare against
+		checksums, err := hashFile(file)
+		if err != nil {
+			return false, fmt.Errorf("failed to hash .deb file: %w", err)
+		}
+		return a.resolveConflict(record, checksums.SHA256)
+	}
+
+	return true, nil
+}
+
+// packagesUpdate computes the new stanzas of a Packages index from its current ones.
+// It reports false when the index does not need to be written. It may be called
+// again with fresh stanzas when the index changes concurrently.
+type packagesUpdate func(records []deb.PackageRecord) ([]deb.PackageRecord, bool, error)
+
+// renderPackageReleaseFile renders the Release file of the architecture-specific
+// index of component and architecture, listing the size and checksums of every listed
+// Packages variant among files.
+func (a *applicationImpl) renderPackageReleaseFile(component, architecture string, files []indexFile) *bytes.Buffer {
+	releaseContent := deb.ReleaseFileContent{
+		Origin:       a.config.Origin,
+		Label:        a.config.Label,
+		Archive:      a.config.Archive,
+		Component:    component,
+		Architecture: architecture,
+		Date:         a.config.Date,
+	}
+	for _, file := range files {
+		if a.listedIndexFile(file.path) {
+			releaseContent.AddChecksums(filepath.Base(file.path), deb.ComputeChecksums(file.data.Bytes()))
+		}
+	}
+
+	return bytes.NewBufferString(deb.CreatePackageReleaseFileContents(releaseContent))
+}
+
+// UploadSuiteReleaseFile writes the suite-level Release file, listing the size and
+// checksums of every Packages index and per-architecture Release file found below it.
+// The Architectures and Components fields list what has an index below the suite, or
+// the configured overrides, together with the architectures and components just published.
+func (a *applicationImpl) UploadSuiteReleaseFile(ctx context.Context, suiteReleasePath string, architectures, components []string) error {
+	suiteDir := filepath.Dir(suiteReleasePath)
+	indices, err := a.listSuiteIndices(ctx, suiteDir)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to download Packages file: %v", err)
+		return err
 	}
 
-	// Convert package metadata to Packages file format
-	newPackageContent := deb.CreatePackagesFileContents(mapMetadataToPackageContents(metadata))
+	// Discovered lists are sorted, so the Release file does not depend on the order
+	// things were published in
+	suiteComponents, suiteArchitectures := suiteLayout(indices)
+	suiteComponents = appendMissing(suiteComponents, components...)
+	suiteArchitectures = appendMissing(suiteArchitectures, architectures...)
+	sort.Strings(suiteComponents)
+	sort.Strings(suiteArchitectures)
+	if len(a.config.Components) > 0 {
+		suiteComponents = appendMissing(slices.Clone(a.config.Components), components...)
+	}
+	if len(a.config.Architectures) > 0 {
+		suiteArchitectures = appendMissing(slices.Clone(a.config.Architectures), architectures...)
+	}
+	a.logger.Debugf("Suite has components %v and architectures %v", suiteComponents, suiteArchitectures)
+
+	releaseContent := deb.ReleaseFileContent{
+		Origin:        a.config.Origin,
+		Label:         a.config.Label,
+		Archive:       a.config.Archive,
+		Architecture:  strings.Join(suiteArchitectures, " "),
+		Component:     strings.Join(suiteComponents, " "),
+		AcquireByHash: true,
+		Date:          a.config.Date,
+	}
 
-	// Log buffer lengths for debugging
-	a.logger.Debugf("Existing Packages buffer length: %d", existingPackagesBuffer.Len())
-	a.logger.Debugf("New package content length: %d", len(newPackageContent))
+	byHashObjects, err := a.byHashObjects(ctx, suiteDir)
+	if err != nil {
+		return err
+	}
 
-	// Check if the package metadata already exists
-	if !strings.Contains(existingPackagesBuffer.String(), newPackageContent) {
-		// Append new package content to existing Packages buffer
-		if existingPackagesBuffer.Len() > 0 {
-			existingPackagesBuffer.WriteString("\n")
+	// Hash every index below the suite so apt can verify what it downloads
+	for _, index := range indices {
+		if !a.listedIndexFile(index) {
+			continue
+		}
+		var indexBuffer bytes.Buffer
+		err := a.storage.DownloadFile(ctx, filepath.Join(suiteDir, index), &indexBuffer)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", index, err)
+		}
+		checksums := deb.ComputeChecksums(indexBuffer.Bytes())
+		releaseContent.AddChecksums(index, checksums)
+
+		// apt fetches every listed index by hash once it is advertised, so an index
+		// written before by-hash copies were kept, or by another tool, turns it off
+		for _, path := range byHashPaths(checksums) {
+			if releaseContent.AcquireByHash && !byHashObjects[filepath.Join(suiteDir, filepath.Dir(index), path)] {
+				a.logger.Infof("Not advertising Acquire-By-Hash; %s has no by-hash copy", index)
+				releaseContent.AcquireByHash = false
+			}
 		}
-		existingPackagesBuffer.WriteString(newPackageContent)
-	} else {
-		a.logger.Info("Package metadata already exists in Packages file; skipping append")
 	}
 
-	// Proceed to upload and compress the Packages file
-	err = a.storage.UploadBuffer(ctx, packagesPath, existingPackagesBuffer)
+	releaseData := []byte(deb.CreateSuiteReleaseFileContents(releaseContent))
+
+	// Sign before replacing anything, so a signing failure leaves the current Release files alone
+	inRelease, releaseGpg, err := a.signRelease(releaseData)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to upload Packages file: %w", err)
+		return err
+	}
+
+	// Signatures of an earlier signed publish would no longer match the new Release
+	// file, and apt rejects a suite whose InRelease does not verify. checkSigning has
+	// made sure that dropping them was allowed.
+	if inRelease == nil {
+		for _, name := range []string{"InRelease", "Release.gpg"} {
+			if err := a.storage.Delete(ctx, filepath.Join(suiteDir, name)); err != nil {
+				return fmt.Errorf("failed to delete %s file: %w", name, err)
+			}
+		}
 	}
 
-	// Compress the Packages file into Packages.gz
-	packagesGzBuffer, err := compressGzip(existingPackagesBuffer)
+	// Upload the suite-level Release file and its signatures last, once every index
+	// they refer to is in place
+	err = a.storage.UploadBuffer(ctx, suiteReleasePath, bytes.NewBuffer(releaseData))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to compress Packages.gz: %v", err)
+		return fmt.Errorf("failed to upload suite-level Release file: %v", err)
+	}
+	if inRelease == nil {
+		return nil
 	}
 
-	// Upload the Packages.gz file
-	packagesGzPath := packagesPath + ".gz"
-	err = a.storage.UploadBuffer(ctx, packagesGzPath, packagesGzBuffer)
+	err = a.storage.UploadBuffer(ctx, filepath.Join(suiteDir, "Release.gpg"), bytes.NewBuffer(releaseGpg))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to upload Packages.gz file: %v", err)
+		return fmt.Errorf("failed to upload Release.gpg file: %w", err)
+	}
+	err = a.storage.UploadBuffer(ctx, filepath.Join(suiteDir, "InRelease"), bytes.NewBuffer(inRelease))
+	if err != nil {
+		return fmt.Errorf("failed to upload InRelease file: %w", err)
 	}
 
-	return existingPackagesBuffer, packagesGzBuffer, nil
+	return nil
 }
 
-func (a *applicationImpl) UploadPackageReleaseFile(ctx context.Context, releasePath string, packagesBuffer, packagesGzBuffer *bytes.Buffer) error {
-	// Initialize the SHA256 slice
-	var checksums []deb.ChecksumInfo
+// signRelease returns the clearsigned InRelease and the detached Release.gpg
+// signature of a Release file. Both are nil when no signing key is configured.
+func (a *applicationImpl) signRelease(releaseData []byte) ([]byte, []byte, error) {
+	if a.signer == nil {
+		a.logger.Debug("No GPG key configured; skipping Release signing")
+		return nil, nil, nil
+	}
 
-	// Compute checksum and size for Packages
-	packagesHash := sha256.New()
-	_, err := packagesHash.Write(packagesBuffer.Bytes())
+	inRelease, err := a.signer.ClearSign(releaseData)
 	if err != nil {
-		return fmt.Errorf("failed to compute sha256 for Packages: %v", err)
+		return nil, nil, fmt.Errorf("failed to clearsign Release file: %w", err)
+	}
+	releaseGpg, err := a.signer.DetachSign(releaseData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign Release file: %w", err)
 	}
-	packagesSha256Sum := fmt.Sprintf("%x", packagesHash.Sum(nil))
-	packagesSize := int64(packagesBuffer.Len())
 
-	checksums = append(checksums, deb.ChecksumInfo{
-		Checksum: packagesSha256Sum,
-		Size:     packagesSize,
-		Filename: "Packages",
-	})
+	return inRelease, releaseGpg, nil
+}
 
-	// Compute checksum and size for Packages.gz
-	packagesGzHash := sha256.New()
-	_, err = packagesGzHash.Write(packagesGzBuffer.Bytes())
+// checkSigning refuses to change a suite that has an InRelease file when no signing
+// key is configured, unless Config.AllowUnsigned is set: the signatures would be
+// deleted, and clients that trust the suite would fail to update.
+func (a *applicationImpl) checkSigning(ctx context.Context) error {
+	if a.signer != nil || a.config.AllowUnsigned {
+		return nil
+	}
+
+	inReleasePath := filepath.Join(a.suiteDir(), "InRelease")
+	var inRelease bytes.Buffer
+	err := a.storage.DownloadFile(ctx, inReleasePath, &inRelease)
+	if storage.IsNotFoundError(err) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to compute sha256 for Packages.gz: %v", err)
+		return fmt.Errorf("failed to download %s: %w", inReleasePath, err)
 	}
-	packagesGzSha256Sum := fmt.Sprintf("%x", packagesGzHash.Sum(nil))
-	packagesGzSize := int64(packagesGzBuffer.Len())
+	return fmt.Errorf("%w: %s exists; configure the signing key, or allow unsigned publishing to drop the signatures", ErrSignedSuite, inReleasePath)
+}
 
-	checksums = append(checksums, deb.ChecksumInfo{
-		Checksum: packagesGzSha256Sum,
-		Size:     packagesGzSize,
-		Filename: "Packages.gz",
-	})
+// listSuiteIndices returns the paths, relative to suiteDir, of every Packages index
+// and per-architecture Release file stored below the suite, sorted by path.
+func (a *applicationImpl) listSuiteIndices(ctx context.Context, suiteDir string) ([]string, error) {
+	objects, err := a.storage.List(ctx, suiteDir+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list suite indices: %w", err)
+	}
 
-	// Construct the Release file content
-	releaseContent := deb.CreatePackageReleaseFileContents(deb.ReleaseFileContent{
-		Origin:       a.config.Origin,
-		Label:        a.config.Label,
-		Archive:      a.config.Archive,
-		Component:    a.config.Component,
-		Architecture: a.config.Architecture,
-		SHA256:       checksums,
-	})
+	var indices []string
+	for _, object := range objects {
+		relativePath := strings.TrimPrefix(object.Key, suiteDir+"/")
+		if isSuiteIndex(relativePath) {
+			indices = append(indices, relativePath)
+		}
+	}
+	sort.Strings(indices)
 
-	// Upload the Release file
-	err = a.storage.UploadBuffer(ctx, releasePath, bytes.NewBufferString(releaseContent))
-	if err != nil {
-		return fmt.Errorf("failed to upload Release file: %v", err)
+	a.logger.Debugf("Found %d indices below %s", len(indices), suiteDir)
+	return indices, nil
+}
+
+// mergePackageRecord adds record to records, resolving a stanza with the same key
+// according to the conflict policy.
+func (a *applicationImpl) mergePackageRecord(records []deb.PackageRecord, record deb.PackageRecord) ([]deb.PackageRecord, error) {
+	for i, existing := range records {
+		if existing.Key != record.Key {
+			continue
+		}
+
+		replace, err := a.resolveConflict(existing, record.SHA256())
+		if err != nil {
+			return nil, err
+		}
+		if replace {
+			a.logger.Infof("Replacing existing stanza for %s", record.Key)
+			records[i] = record
+		}
+		return records, nil
 	}
 
-	return nil
+	return append(records, record), nil
 }
 
-func (a *applicationImpl) UploadSuiteReleaseFile(ctx context.Context, suiteReleasePath string, architectures, components []string) error {
-	// Construct the Release file content for the entire suite
-	releaseContent := deb.CreateSuiteReleaseFileContents(deb.ReleaseFileContent{
-		Origin:       a.config.Origin,
-		Label:        a.config.Label,
-		Archive:      a.config.Archive,
-		Architecture: strings.Join(architectures, " "),
-		Component:    strings.Join(components, " "),
-	})
+// resolveConflict reports whether existing should be replaced by a package with the given checksum.
+func (a *applicationImpl) resolveConflict(existing deb.PackageRecord, sha256 string) (bool, error) {
+	switch a.config.OnConflict {
+	case ConflictSkip:
+		a.logger.Infof("%s is already published; skipping", existing.Key)
+		return false, nil
+	case ConflictFail:
+		if existing.SHA256() != sha256 {
+			return false, fmt.Errorf("%s: %w", existing.Key, ErrPackageConflict)
+		}
+		a.logger.Infof("%s is already published with the same checksum; skipping", existing.Key)
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// suiteDir returns the directory of the configured suite below dists/.
+func (a *applicationImpl) suiteDir() string {
+	return filepath.Join("dists", a.config.Archive)
+}
 
-	// Upload the suite-level Release file
-	err := a.storage.UploadBuffer(ctx, suiteReleasePath, bytes.NewBufferString(releaseContent))
+// suiteReleasePath returns the path of the suite-level Release file.
+func (a *applicationImpl) suiteReleasePath() string {
+	return filepath.Join(a.suiteDir(), "Release")
+}
+
+// downloadPackagesRecords downloads and parses the Packages file at packagesPath and
+// returns its stanzas with the ETag it was read with. A missing file has an empty ETag
+// and the stanzas of its newest by-hash generation or compressed variants; only an
+// index directory without any file starts empty. A file that does not parse, or a
+// missing one whose fallbacks cannot be read, fails with errInvalidPackagesIndex,
+// still returning its ETag.
+func (a *applicationImpl) downloadPackagesRecords(ctx context.Context, packagesPath string) ([]deb.PackageRecord, string, error) {
+	var packagesBuffer bytes.Buffer
+	etag, err := a.storage.DownloadFileWithETag(ctx, packagesPath, &packagesBuffer)
+	if storage.IsNotFoundError(err) {
+		records, err := a.readIndexFallback(ctx, packagesPath, nil)
+		if err == nil && records == nil {
+			a.logger.Infof("No existing Packages file found at %s; starting fresh", packagesPath)
+		}
+		return records, "", err
+	}
 	if err != nil {
-		return fmt.Errorf("failed to upload suite-level Release file: %v", err)
+		return nil, "", fmt.Errorf("failed to download Packages file: %w", err)
 	}
 
-	return nil
+	records, err := deb.ParsePackagesFile(packagesBuffer.String())
+	if err != nil {
+		return nil, etag, fmt.Errorf("failed to parse %s: %w: %w", packagesPath, errInvalidPackagesIndex, err)
+	}
+	return records, etag, nil
 }
 
 func (a *applicationImpl) downloadPackagesFromStorage(ctx context.Context, packagesPath string) (*bytes.Buffer, error) {
@@ -1,16 +1,30 @@
 package application
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"github.com/blakesmith/ar"
 	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/deb822"
 	"github.com/pavliha/aptforge/internal/filereader"
 	"github.com/pavliha/aptforge/internal/storage"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Mock implementations remain the same unless methods have changed
@@ -47,6 +61,27 @@ func (m *MockFile) Stat() (os.FileInfo, error) {
 	return args.Get(0).(os.FileInfo), args.Error(1)
 }
 
+// BytesFile is an in-memory filereader.File
+type BytesFile struct {
+	Reader *bytes.Reader
+}
+
+func (b *BytesFile) Read(p []byte) (int, error) {
+	return b.Reader.Read(p)
+}
+
+func (b *BytesFile) Seek(offset int64, whence int) (int64, error) {
+	return b.Reader.Seek(offset, whence)
+}
+
+func (b *BytesFile) Close() error {
+	return nil
+}
+
+func (b *BytesFile) Stat() (os.FileInfo, error) {
+	return nil, nil
+}
+
 // Update MockStorage to include DownloadFile
 type MockStorage struct {
 	mock.Mock
@@ -62,6 +97,11 @@ func (m *MockStorage) UploadBuffer(ctx context.Context, path string, buffer *byt
 	return args.Error(0)
 }
 
+func (m *MockStorage) UploadBufferIfMatch(ctx context.Context, path string, buffer *bytes.Buffer, etag string) error {
+	args := m.Called(ctx, path, buffer, etag)
+	return args.Error(0)
+}
+
 func (m *MockStorage) Download(ctx context.Context, path string) (storage.Object, error) {
 	args := m.Called(ctx, path)
 	return args.Get(0).(storage.Object), args.Error(1)
@@ -72,6 +112,21 @@ func (m *MockStorage) DownloadFile(ctx context.Context, path string, dest *bytes
 	return args.Error(0)
 }
 
+func (m *MockStorage) DownloadFileWithETag(ctx context.Context, path string, dest *bytes.Buffer) (string, error) {
+	args := m.Called(ctx, path, dest)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	args := m.Called(ctx, prefix)
+	return args.Get(0).([]storage.ObjectInfo), args.Error(1)
+}
+
+func (m *MockStorage) Delete(ctx context.Context, path string) error {
+	args := m.Called(ctx, path)
+	return args.Error(0)
+}
+
 func (m *MockStorage) IsNotFoundError(err error) bool {
 	args := m.Called(err)
 	return args.Bool(0)
@@ -81,11 +136,186 @@ type MockDebExtractor struct {
 	mock.Mock
 }
 
-func (m *MockDebExtractor) ExtractPackageMetadata(file filereader.File) (*deb.PackageMetadata, error) {
+func (m *MockDebExtractor) Validate(file filereader.File) error {
+	args := m.Called(file)
+	return args.Error(0)
+}
+
+func (m *MockDebExtractor) ExtractPackageMetadata(file io.Reader) (*deb.PackageMetadata, error) {
 	args := m.Called(file)
 	return args.Get(0).(*deb.PackageMetadata), args.Error(1)
 }
 
+type MockSigner struct {
+	mock.Mock
+}
+
+func (m *MockSigner) ClearSign(data []byte) ([]byte, error) {
+	args := m.Called(data)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockSigner) DetachSign(data []byte) ([]byte, error) {
+	args := m.Called(data)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+// MemoryStorage is an in-memory storage.Storage for tests that exercise whole commands.
+// It counts the uploads of every path and uses the MD5 of an object as its ETag.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]int
+	modified map[string]time.Time
+	// uploadOrder lists the uploaded paths in the order they were written
+	uploadOrder []string
+	// beforeConditionalUpload, when set, runs before the ETag of a conditional upload is checked
+	beforeConditionalUpload func(path string)
+	// failUpload, when set, fails the uploads of the paths it returns an error for
+	failUpload func(path string) error
+	// afterUpload, when set, runs after an object was stored; the caller holds the lock
+	afterUpload func(path string)
+	// noConditionalWrites rejects UploadBufferIfMatch like storage without conditional writes
+	noConditionalWrites bool
+}
+
+// upload stores data at path; the caller holds the lock.
+func (m *MemoryStorage) upload(path string, data []byte) error {
+	if m.failUpload != nil {
+		if err := m.failUpload(path); err != nil {
+			return err
+		}
+	}
+	m.objects[path] = data
+	m.uploads[path]++
+	m.modified[path] = time.Now()
+	m.uploadOrder = append(m.uploadOrder, path)
+	if m.afterUpload != nil {
+		m.afterUpload(path)
+	}
+	return nil
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string][]byte), uploads: make(map[string]int), modified: make(map[string]time.Time)}
+}
+
+func (m *MemoryStorage) UploadFile(ctx context.Context, path string, file filereader.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.upload(path, data)
+}
+
+func (m *MemoryStorage) UploadBuffer(ctx context.Context, path string, buffer *bytes.Buffer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.upload(path, bytes.Clone(buffer.Bytes()))
+}
+
+func (m *MemoryStorage) UploadBufferIfMatch(ctx context.Context, path string, buffer *bytes.Buffer, etag string) error {
+	if m.beforeConditionalUpload != nil {
+		m.beforeConditionalUpload(path)
+	}
+
+	if m.noConditionalWrites {
+		return fmt.Errorf("%s: conditional writes are not implemented", path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.objects[path]
+	if (etag == "" && found) || (etag != "" && (!found || memoryETag(data) != etag)) {
+		return storage.ErrPreconditionFailed
+	}
+	return m.upload(path, bytes.Clone(buffer.Bytes()))
+}
+
+func (m *MemoryStorage) Download(ctx context.Context, path string) (storage.Object, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.objects[path]
+	if !found {
+		return nil, storage.ErrNotFound
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (m *MemoryStorage) DownloadFile(ctx context.Context, path string, dest *bytes.Buffer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.objects[path]
+	if !found {
+		return storage.ErrNotFound
+	}
+	dest.Write(data)
+	return nil
+}
+
+func (m *MemoryStorage) DownloadFileWithETag(ctx context.Context, path string, dest *bytes.Buffer) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.objects[path]
+	if !found {
+		return "", storage.ErrNotFound
+	}
+	dest.Write(data)
+	return memoryETag(data), nil
+}
+
+func memoryETag(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
+func (m *MemoryStorage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []storage.ObjectInfo
+	for key, data := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.ObjectInfo{Key: key, Size: int64(len(data)), LastModified: m.modified[key], ETag: memoryETag(data)})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, path)
+	return nil
+}
+
+// newMemoryApplication returns an application backed by MemoryStorage that publishes
+// the given package metadata for any .deb it loads.
+func newMemoryApplication(config *Config, packages map[string]*deb.PackageMetadata) (*applicationImpl, *MemoryStorage) {
+	memoryStorage := NewMemoryStorage()
+	mockFileReader := new(MockFileReader)
+	mockExtractor := new(MockDebExtractor)
+	for path, metadata := range packages {
+		file := &BytesFile{Reader: bytes.NewReader([]byte("content of " + path))}
+		mockFileReader.On("Open", path).Return(file, nil)
+		mockExtractor.On("Validate", file).Return(nil)
+		mockExtractor.On("ExtractPackageMetadata", file).Return(metadata, nil)
+	}
+
+	return &applicationImpl{
+		logger:     log.NewEntry(log.New()),
+		storage:    memoryStorage,
+		fileReader: mockFileReader,
+		extractor:  mockExtractor,
+		config:     config,
+	}, memoryStorage
+}
+
 // Test LoadDebFile remains the same
 func TestLoadDebFile(t *testing.T) {
 	mockFileReader := new(MockFileReader)
@@ -128,6 +358,7 @@ func TestExtractDebMetadata(t *testing.T) {
 	}
 
 	mockDebExtractor := new(MockDebExtractor)
+	mockDebExtractor.On("Validate", mockFile).Return(nil)
 	mockDebExtractor.On("ExtractPackageMetadata", mockFile).Return(mockMetadata, nil)
 
 	app := applicationImpl{
@@ -140,10 +371,58 @@ func TestExtractDebMetadata(t *testing.T) {
 	assert.Equal(t, "testpkg", metadata.PackageName)
 }
 
-// Test UploadDebFile remains the same
+// Test ExtractDebMetadata stops at an invalid archive
+func TestExtractDebMetadataInvalid(t *testing.T) {
+	mockFile := new(MockFile)
+	mockDebExtractor := new(MockDebExtractor)
+	mockDebExtractor.On("Validate", mockFile).Return(fmt.Errorf("%w: missing data archive", deb.ErrInvalidPackage))
+
+	app := applicationImpl{
+		logger:    log.NewEntry(log.New()),
+		extractor: mockDebExtractor,
+	}
+
+	_, err := app.ExtractDebMetadata(mockFile)
+	assert.ErrorIs(t, err, deb.ErrInvalidPackage)
+	mockDebExtractor.AssertNotCalled(t, "ExtractPackageMetadata", mock.Anything)
+}
+
+// Test ExtractDebMetadata rejects a package name or version that is not valid in Debian,
+// since both become part of the pool path
+func TestExtractDebMetadataInvalidFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata *deb.PackageMetadata
+	}{
+		{name: "version with path", metadata: &deb.PackageMetadata{PackageName: "tool", Version: "1/../../../o/other/other_2.0", Architecture: "amd64"}},
+		{name: "empty version", metadata: &deb.PackageMetadata{PackageName: "tool", Architecture: "amd64"}},
+		{name: "name with path", metadata: &deb.PackageMetadata{PackageName: "../other", Version: "1.0", Architecture: "amd64"}},
+		{name: "uppercase name", metadata: &deb.PackageMetadata{PackageName: "Tool", Version: "1.0", Architecture: "amd64"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockFile := new(MockFile)
+			mockDebExtractor := new(MockDebExtractor)
+			mockDebExtractor.On("Validate", mockFile).Return(nil)
+			mockDebExtractor.On("ExtractPackageMetadata", mockFile).Return(tt.metadata, nil)
+
+			app := applicationImpl{
+				logger:    log.NewEntry(log.New()),
+				extractor: mockDebExtractor,
+			}
+
+			_, err := app.ExtractDebMetadata(mockFile)
+			assert.ErrorContains(t, err, "invalid .deb file")
+		})
+	}
+}
+
+// Test UploadDebFile hashes the file while it is uploaded
 func TestUploadDebFile(t *testing.T) {
 	mockStorage := new(MockStorage)
-	mockFile := new(MockFile)
+	debContent := []byte("fake deb content")
+	file := &BytesFile{Reader: bytes.NewReader(debContent)}
 	mockMetadata := &deb.PackageMetadata{
 		PackageName:  "testpkg",
 		Version:      "1.0",
@@ -151,17 +430,26 @@ func TestUploadDebFile(t *testing.T) {
 	}
 
 	expectedPath := "pool/main/t/testpkg/testpkg_1.0_amd64.deb"
-	mockStorage.On("UploadFile", mock.Anything, expectedPath, mockFile).Return(nil)
+	mockStorage.On("UploadFile", mock.Anything, expectedPath, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		uploaded := args.Get(2).(filereader.File)
+		_, err := uploaded.Seek(0, io.SeekStart)
+		assert.NoError(t, err)
+		_, err = io.Copy(io.Discard, uploaded)
+		assert.NoError(t, err)
+	})
 
 	app := applicationImpl{
+		logger:  log.NewEntry(log.New()),
 		storage: mockStorage,
 		config: &Config{
 			Component: "main",
 		},
 	}
 
-	err := app.UploadDebFile(context.Background(), mockMetadata, mockFile)
+	err := app.UploadDebFile(context.Background(), mockMetadata, file)
 	assert.NoError(t, err)
+	assert.Equal(t, expectedPath, mockMetadata.Filename)
+	assert.Equal(t, deb.ComputeChecksums(debContent), mockMetadata.Checksums)
 	mockStorage.AssertExpectations(t)
 }
 
@@ -185,57 +473,1817 @@ func TestDownloadPackagesFromStorage(t *testing.T) {
 	mockStorage.AssertExpectations(t)
 }
 
-// Updated Test UpdatePackagesFile
-func TestUpdatePackagesFile(t *testing.T) {
-	mockStorage := new(MockStorage)
-	mockMetadata := &deb.PackageMetadata{
-		PackageName:  "testpkg",
-		Version:      "1.0",
-		Architecture: "amd64",
+// Test Publish merges the package into the existing index with its pool path, size and checksums
+func TestPublishMergesExistingIndex(t *testing.T) {
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, map[string]*deb.PackageMetadata{
+		"testpkg_1.0_amd64.deb": {PackageName: "testpkg", Version: "1.0", Architecture: "amd64"},
+	})
+	ctx := context.Background()
+	indexDir := "dists/stable/main/binary-amd64/"
+	existingPackagesContent := "Package: otherpkg\nArchitecture: amd64\nVersion: 2.0\n"
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, indexDir+"Packages", bytes.NewBufferString(existingPackagesContent)))
+
+	assert.NoError(t, app.Publish(ctx, []string{"testpkg_1.0_amd64.deb"}))
+
+	packages := string(memoryStorage.objects[indexDir+"Packages"])
+	checksums := deb.ComputeChecksums([]byte("content of testpkg_1.0_amd64.deb"))
+	assert.Contains(t, packages, "Package: otherpkg\nArchitecture: amd64\nVersion: 2.0\n")
+	assert.Contains(t, packages, "Package: testpkg\n")
+	assert.Contains(t, packages, "Filename: pool/main/t/testpkg/testpkg_1.0_amd64.deb\n")
+	assert.Contains(t, packages, fmt.Sprintf("Size: %d\n", checksums.Size))
+	assert.Contains(t, packages, "SHA256: "+checksums.SHA256+"\n")
+
+	gzReader, err := gzip.NewReader(bytes.NewReader(memoryStorage.objects[indexDir+"Packages.gz"]))
+	assert.NoError(t, err)
+	packagesData, err := io.ReadAll(gzReader)
+	assert.NoError(t, err)
+	assert.Equal(t, packages, string(packagesData))
+}
+
+// Test Publish writes every control field of the package
+func TestPublishAdditionalFields(t *testing.T) {
+	control, err := deb822.ParseString("Package: libtest1\nVersion: 1.0\nArchitecture: arm64\nMulti-Arch: same\nPre-Depends: libc6\nX-Build-Id: 42\n")
+	assert.NoError(t, err)
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, map[string]*deb.PackageMetadata{
+		"libtest1_1.0_arm64.deb": {PackageName: "libtest1", Version: "1.0", Architecture: "arm64", Control: control[0]},
+	})
+
+	assert.NoError(t, app.Publish(context.Background(), []string{"libtest1_1.0_arm64.deb"}))
+
+	packages := string(memoryStorage.objects["dists/stable/main/binary-arm64/Packages"])
+	assert.Contains(t, packages, "Multi-Arch: same\n")
+	assert.Contains(t, packages, "Pre-Depends: libc6\n")
+	assert.Contains(t, packages, "X-Build-Id: 42\n")
+	assert.Equal(t, 1, strings.Count(packages, "Package: libtest1"))
+}
+
+// Test Publish resolves a stanza that is already published according to the conflict
+// policy, and leaves the pool file alone when the package is not published
+func TestPublishConflict(t *testing.T) {
+	published := deb.ComputeChecksums([]byte("content of testpkg_1.0_amd64.deb"))
+
+	tests := []struct {
+		name           string
+		policy         ConflictPolicy
+		version        string
+		existingSHA256 string
+		expectedError  error
+		expectedSHA256 string
+		expectedUpload bool
+	}{
+		{name: "replace", policy: ConflictReplace, version: "1.0", existingSHA256: "old", expectedSHA256: published.SHA256, expectedUpload: true},
+		{name: "default replaces", policy: "", version: "1.0", existingSHA256: "old", expectedSHA256: published.SHA256, expectedUpload: true},
+		{name: "skip", policy: ConflictSkip, version: "1.0", existingSHA256: "old", expectedSHA256: "old"},
+		{name: "fail with same checksum", policy: ConflictFail, version: "1.0", existingSHA256: published.SHA256, expectedSHA256: published.SHA256},
+		{name: "fail with different checksum", policy: ConflictFail, version: "1.0", existingSHA256: "old", expectedError: ErrPackageConflict},
+		{name: "fail with another version", policy: ConflictFail, version: "0.9", existingSHA256: "old", expectedSHA256: published.SHA256, expectedUpload: true},
 	}
-	existingPackagesContent := "existing packages content"
 
-	// Mock DownloadFile to populate the buffer
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", OnConflict: tt.policy}, map[string]*deb.PackageMetadata{
+				"testpkg_1.0_amd64.deb": {PackageName: "testpkg", Version: "1.0", Architecture: "amd64"},
+			})
+			ctx := context.Background()
+			packagesPath := "dists/stable/main/binary-amd64/Packages"
+			existing := fmt.Sprintf("Package: testpkg\nVersion: %s\nArchitecture: amd64\nSHA256: %s\n\nPackage: otherpkg\nVersion: 2.0\nArchitecture: amd64\n", tt.version, tt.existingSHA256)
+			assert.NoError(t, memoryStorage.UploadBuffer(ctx, packagesPath, bytes.NewBufferString(existing)))
+
+			err := app.Publish(ctx, []string{"testpkg_1.0_amd64.deb"})
+			_, uploaded := memoryStorage.objects["pool/main/t/testpkg/testpkg_1.0_amd64.deb"]
+			assert.Equal(t, tt.expectedUpload, uploaded)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Equal(t, existing, string(memoryStorage.objects[packagesPath]))
+				return
+			}
+			assert.NoError(t, err)
+
+			entries, err := app.Show(ctx, PackageFilter{PackageName: "testpkg", Version: "1.0"})
+			assert.NoError(t, err)
+			if assert.Len(t, entries, 1) {
+				assert.Equal(t, tt.expectedSHA256, entries[0].Record.SHA256())
+			}
+		})
+	}
+}
+
+// Test TargetArchitectures infers the architecture from the package and fans out Architecture: all
+func TestTargetArchitectures(t *testing.T) {
+	tests := []struct {
+		name                  string
+		packageArchitecture   string
+		architecture          string
+		suiteArchitectures    []string
+		policy                Policy
+		expectedArchitectures []string
+		expectedError         error
+	}{
+		{name: "inferred from package", packageArchitecture: "arm64", suiteArchitectures: []string{"amd64", "arm64"}, expectedArchitectures: []string{"arm64"}},
+		{name: "matching target", packageArchitecture: "amd64", architecture: "amd64", expectedArchitectures: []string{"amd64"}},
+		{name: "mismatching target", packageArchitecture: "arm64", architecture: "amd64", expectedError: ErrArchitectureMismatch},
+		{name: "all to suite architectures", packageArchitecture: "all", suiteArchitectures: []string{"amd64", "arm64"}, expectedArchitectures: []string{"amd64", "arm64"}},
+		{name: "all with extra target", packageArchitecture: "all", architecture: "i386", suiteArchitectures: []string{"amd64"}, expectedArchitectures: []string{"amd64", "i386"}},
+		{name: "all to discovered architectures", packageArchitecture: "all", expectedArchitectures: []string{"arm64", "i386"}},
+		{name: "architecture outside dpkg table", packageArchitecture: "x86_64"},
+		{name: "architecture not allowed", packageArchitecture: "riscv64", policy: Policy{Architectures: []string{"amd64"}}, expectedError: ErrNotAllowed},
+		{name: "suite architecture not allowed", packageArchitecture: "all", policy: Policy{Architectures: []string{"arm64"}}, expectedError: ErrNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(MockStorage)
+			mockStorage.On("List", mock.Anything, "dists/stable/").Return([]storage.ObjectInfo{
+				{Key: "dists/stable/main/binary-i386/Packages"},
+				{Key: "dists/stable/contrib/binary-arm64/Packages.gz"},
+			}, nil).Maybe()
+
+			app := applicationImpl{
+				logger:  log.NewEntry(log.New()),
+				storage: mockStorage,
+				config:  &Config{Archive: "stable", Architecture: tt.architecture, Architectures: tt.suiteArchitectures, Policy: tt.policy},
+			}
+
+			architectures, err := app.TargetArchitectures(context.Background(), &deb.PackageMetadata{PackageName: "testpkg", Version: "1.0", Architecture: tt.packageArchitecture})
+			if tt.expectedArchitectures == nil && tt.expectedError == nil {
+				assert.Error(t, err)
+				return
+			}
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedArchitectures, architectures)
+		})
+	}
+}
+
+// Test Policy applies the naming rules and restricts values to the allowed lists
+func TestPolicy(t *testing.T) {
+	unrestricted := Policy{}
+	assert.NoError(t, unrestricted.CheckSuite("bookworm"))
+	assert.NoError(t, unrestricted.CheckComponent("nightly"))
+	assert.NoError(t, unrestricted.CheckArchitecture("riscv64"))
+	assert.Error(t, unrestricted.CheckSuite("../stable"))
+	assert.Error(t, unrestricted.CheckComponent("Main"))
+	assert.Error(t, unrestricted.CheckArchitecture("x86_64"))
+
+	restricted := Policy{Suites: []string{"jammy"}, Components: []string{"main"}, Architectures: []string{"amd64", "armhf"}}
+	assert.NoError(t, restricted.CheckSuite("jammy"))
+	assert.NoError(t, restricted.CheckComponent("main"))
+	assert.NoError(t, restricted.CheckArchitecture("armhf"))
+	assert.ErrorIs(t, restricted.CheckSuite("bookworm"), ErrNotAllowed)
+	assert.ErrorIs(t, restricted.CheckComponent("nightly"), ErrNotAllowed)
+	assert.ErrorIs(t, restricted.CheckArchitecture("arm64"), ErrNotAllowed)
+}
+
+// Test the policy stored in the repository is enforced by every run that writes,
+// whether or not the run is given a policy of its own
+func TestStoredPolicy(t *testing.T) {
+	config := &Config{Archive: "stable", Component: "main"}
+	app, memoryStorage := newMemoryApplication(config, map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_1.0_arm64.deb": {PackageName: "tool", Version: "1.0", Architecture: "arm64"},
+		"docs_1.0_all.deb":   {PackageName: "docs", Version: "1.0", Architecture: "all"},
+	})
+	ctx := context.Background()
+
+	policy, err := app.StoredPolicy(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, RepositoryPolicy{}, policy)
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_arm64.deb"}))
+
+	_, err = app.UpdatePolicy(ctx, func(policy *RepositoryPolicy) { policy.Architectures = []string{"x86_64"} })
+	assert.Error(t, err, "stored values follow the naming rules")
+	_, err = app.UpdatePolicy(ctx, func(policy *RepositoryPolicy) {
+		policy.Suites = []string{"stable"}
+		policy.Architectures = []string{"amd64"}
+	})
+	assert.NoError(t, err)
+	policy, err = app.UpdatePolicy(ctx, func(policy *RepositoryPolicy) { policy.Components = []string{"main"} })
+	assert.NoError(t, err)
+	assert.Equal(t, Policy{Suites: []string{"stable"}, Components: []string{"main"}, Architectures: []string{"amd64"}}, policy.Policy)
+	stored, err := app.StoredPolicy(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, policy, stored)
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	assert.ErrorIs(t, app.Publish(ctx, []string{"tool_1.0_arm64.deb"}), ErrNotAllowed)
+	// The suite has an arm64 index from before the policy, which Architecture: all packages would go to
+	assert.ErrorIs(t, app.Publish(ctx, []string{"docs_1.0_all.deb"}), ErrNotAllowed)
+	assert.NotContains(t, memoryStorage.objects, "pool/main/d/docs/docs_1.0_all.deb")
+
+	config.Component = "contrib"
+	assert.ErrorIs(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}), ErrNotAllowed)
+	_, err = app.Remove(ctx, RemoveRequest{PackageName: "tool"})
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	assert.ErrorIs(t, app.Reindex(ctx, ReindexRequest{}), ErrNotAllowed)
+	config.Component = "main"
+	config.Archive = "testing"
+	assert.ErrorIs(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}), ErrNotAllowed)
+	config.Archive = "stable"
+
+	// A policy that cannot be parsed stops every write rather than allowing everything
+	memoryStorage.objects[policyPath] = []byte("{")
+	assert.ErrorContains(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}), policyPath)
+	assert.NoError(t, app.ClearPolicy(ctx))
+	assert.NoError(t, app.Publish(ctx, []string{"docs_1.0_all.deb"}))
+}
+
+// Test UploadSuiteReleaseFile hashes every index below the suite
+func TestUploadSuiteReleaseFile(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("List", mock.Anything, "dists/stable/").Return([]storage.ObjectInfo{
+		{Key: "dists/stable/Release"},
+		{Key: "dists/stable/main/binary-amd64/Packages"},
+		{Key: "dists/stable/main/binary-amd64/Packages.gz"},
+		{Key: "dists/stable/main/binary-amd64/Release"},
+		{Key: "dists/stable/main/binary-amd64/unrelated.txt"},
+	}, nil)
 	mockStorage.On("DownloadFile", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		dest := args.Get(2).(*bytes.Buffer)
-		dest.WriteString(existingPackagesContent)
+		dest.WriteString("content of " + args.String(1))
 	})
 
-	// Mock UploadBuffer for Packages and Packages.gz
-	mockStorage.On("UploadBuffer", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	var uploaded string
+	mockStorage.On("UploadBuffer", mock.Anything, "dists/stable/Release", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		uploaded = args.Get(2).(*bytes.Buffer).String()
+	})
+	// Without a signer, the signatures of an earlier signed publish are deleted
+	mockStorage.On("Delete", mock.Anything, "dists/stable/InRelease").Return(nil)
+	mockStorage.On("Delete", mock.Anything, "dists/stable/Release.gpg").Return(nil)
 
 	app := applicationImpl{
-		storage: mockStorage,
 		logger:  log.NewEntry(log.New()),
+		storage: mockStorage,
+		config: &Config{
+			Archive: "stable",
+			Origin:  "origin",
+			Label:   "Test Repo",
+		},
 	}
 
-	packagesBuffer, packagesGzBuffer, err := app.UpdatePackagesFile(context.Background(), "packages-path", mockMetadata)
+	err := app.UploadSuiteReleaseFile(context.Background(), "dists/stable/Release", []string{"amd64"}, []string{"main"})
 	assert.NoError(t, err)
-	assert.Contains(t, packagesBuffer.String(), "testpkg")
-	assert.NotNil(t, packagesGzBuffer)
+
+	assert.Contains(t, uploaded, "Architectures: amd64\n")
+	assert.Contains(t, uploaded, "Components: main\n")
+	packages := deb.ComputeChecksums([]byte("content of dists/stable/main/binary-amd64/Packages"))
+	for _, section := range []string{"MD5Sum:\n", "SHA1:\n", "SHA256:\n", "SHA512:\n"} {
+		assert.Contains(t, uploaded, section)
+	}
+	assert.Contains(t, uploaded, " "+packages.MD5+" 50 main/binary-amd64/Packages\n")
+	assert.Contains(t, uploaded, " "+packages.SHA256+" 50 main/binary-amd64/Packages\n")
+	assert.Contains(t, uploaded, " main/binary-amd64/Packages.gz\n")
+	assert.Contains(t, uploaded, " main/binary-amd64/Release\n")
+	assert.NotContains(t, uploaded, "unrelated.txt")
+	assert.NotContains(t, uploaded, " Release\n")
 	mockStorage.AssertExpectations(t)
 }
 
-// Updated Test UploadReleaseFile
-func TestUploadReleaseFile(t *testing.T) {
+// Test UploadSuiteReleaseFile lists the discovered or configured layout together with what is being published
+func TestUploadSuiteReleaseFileLayout(t *testing.T) {
+	tests := []struct {
+		name                  string
+		config                *Config
+		expectedArchitectures string
+		expectedComponents    string
+	}{
+		{name: "discovered", config: &Config{Archive: "stable"}, expectedArchitectures: "arm64 i386", expectedComponents: "contrib main"},
+		{name: "overridden", config: &Config{Archive: "stable", Architectures: []string{"amd64"}, Components: []string{"main"}}, expectedArchitectures: "amd64 arm64", expectedComponents: "main contrib"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(MockStorage)
+			mockStorage.On("List", mock.Anything, "dists/stable/").Return([]storage.ObjectInfo{
+				{Key: "dists/stable/contrib/binary-i386/Packages"},
+				{Key: "dists/stable/main/binary-i386/Packages"},
+			}, nil)
+			mockStorage.On("DownloadFile", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+			var uploaded string
+			mockStorage.On("UploadBuffer", mock.Anything, "dists/stable/Release", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				uploaded = args.Get(2).(*bytes.Buffer).String()
+			})
+			mockStorage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+			app := applicationImpl{
+				logger:  log.NewEntry(log.New()),
+				storage: mockStorage,
+				config:  tt.config,
+			}
+
+			err := app.UploadSuiteReleaseFile(context.Background(), "dists/stable/Release", []string{"arm64"}, []string{"contrib"})
+			assert.NoError(t, err)
+			assert.Contains(t, uploaded, "Architectures: "+tt.expectedArchitectures+"\n")
+			assert.Contains(t, uploaded, "Components: "+tt.expectedComponents+"\n")
+		})
+	}
+}
+
+// Test UploadSuiteReleaseFile writes InRelease and Release.gpg when a signer is configured
+func TestUploadSuiteReleaseFileSigned(t *testing.T) {
 	mockStorage := new(MockStorage)
-	packagesBuffer := bytes.NewBufferString("Packages content")
-	packagesGzBuffer := bytes.NewBufferString("Packages.gz content")
-	mockStorage.On("UploadBuffer", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockSigner := new(MockSigner)
+	mockStorage.On("List", mock.Anything, "dists/stable/").Return([]storage.ObjectInfo{}, nil)
+
+	var release []byte
+	mockStorage.On("UploadBuffer", mock.Anything, "dists/stable/Release", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		release = bytes.Clone(args.Get(2).(*bytes.Buffer).Bytes())
+	})
+	mockSigner.On("ClearSign", mock.Anything).Return([]byte("clearsigned"), nil)
+	mockSigner.On("DetachSign", mock.Anything).Return([]byte("signature"), nil)
+	mockStorage.On("UploadBuffer", mock.Anything, "dists/stable/InRelease", bytes.NewBufferString("clearsigned")).Return(nil)
+	mockStorage.On("UploadBuffer", mock.Anything, "dists/stable/Release.gpg", bytes.NewBufferString("signature")).Return(nil)
 
 	app := applicationImpl{
 		logger:  log.NewEntry(log.New()),
 		storage: mockStorage,
+		signer:  mockSigner,
 		config: &Config{
-			Archive:      "stable",
-			Component:    "main",
-			Origin:       "origin",
-			Label:        "Test Repo",
-			Architecture: "amd64",
+			Archive: "stable",
 		},
 	}
 
-	err := app.UploadPackageReleaseFile(context.Background(), "release-file-path", packagesBuffer, packagesGzBuffer)
+	err := app.UploadSuiteReleaseFile(context.Background(), "dists/stable/Release", []string{"amd64"}, []string{"main"})
 	assert.NoError(t, err)
+	mockSigner.AssertCalled(t, "ClearSign", release)
+	mockSigner.AssertCalled(t, "DetachSign", release)
 	mockStorage.AssertExpectations(t)
 }
+
+// Test publishing without a signing key is refused for a signed suite, and deletes
+// the signatures of the earlier signed publish once unsigned publishing is allowed
+func TestUploadSuiteReleaseFileSignedToUnsigned(t *testing.T) {
+	config := &Config{Archive: "stable", Component: "main"}
+	app, memoryStorage := newMemoryApplication(config, map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_1.1_amd64.deb": {PackageName: "tool", Version: "1.1", Architecture: "amd64"},
+	})
+	mockSigner := new(MockSigner)
+	mockSigner.On("ClearSign", mock.Anything).Return([]byte("clearsigned"), nil)
+	mockSigner.On("DetachSign", mock.Anything).Return([]byte("signature"), nil)
+	app.signer = mockSigner
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	assert.Contains(t, memoryStorage.objects, "dists/stable/InRelease")
+	assert.Contains(t, memoryStorage.objects, "dists/stable/Release.gpg")
+
+	app.signer = nil
+	packages := memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]
+	assert.ErrorIs(t, app.Publish(ctx, []string{"tool_1.1_amd64.deb"}), ErrSignedSuite)
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "tool"})
+	assert.ErrorIs(t, err, ErrSignedSuite)
+	assert.ErrorIs(t, app.Reindex(ctx, ReindexRequest{}), ErrSignedSuite)
+	assert.Equal(t, packages, memoryStorage.objects["dists/stable/main/binary-amd64/Packages"])
+	assert.NotContains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.1_amd64.deb")
+	assert.Contains(t, memoryStorage.objects, "dists/stable/InRelease")
+
+	config.AllowUnsigned = true
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.1_amd64.deb"}))
+	assert.Contains(t, memoryStorage.objects, "dists/stable/Release")
+	assert.NotContains(t, memoryStorage.objects, "dists/stable/InRelease")
+	assert.NotContains(t, memoryStorage.objects, "dists/stable/Release.gpg")
+}
+
+// Test Publish adds packages to the right indices and List, Show, Remove, Reindex and Verify see them
+func TestRepositoryCommands(t *testing.T) {
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", Architectures: []string{"amd64", "arm64"}}, map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb":  {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_1.1_amd64.deb":  {PackageName: "tool", Version: "1.1", Architecture: "amd64"},
+		"docs_1.0_all.deb":    {PackageName: "docs", Version: "1.0", Architecture: "all"},
+		"other_2.0_arm64.deb": {PackageName: "other", Version: "2.0", Architecture: "arm64"},
+	})
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "tool_1.1_amd64.deb"}))
+	assert.NoError(t, app.Publish(ctx, []string{"docs_1.0_all.deb", "other_2.0_arm64.deb"}))
+	assert.Contains(t, memoryStorage.objects, "pool/main/d/docs/docs_1.0_all.deb")
+	assert.Contains(t, memoryStorage.objects, "dists/stable/main/binary-arm64/Packages.gz")
+	assert.Contains(t, memoryStorage.objects, "dists/stable/Release")
+
+	entries, err := app.List(ctx, PackageFilter{})
+	assert.NoError(t, err)
+	var listed []string
+	for _, entry := range entries {
+		listed = append(listed, entry.Component+"/"+entry.Architecture+"/"+entry.Record.Key.String())
+	}
+	assert.Equal(t, []string{
+		"main/amd64/docs_1.0_all",
+		"main/amd64/tool_1.0_amd64",
+		"main/amd64/tool_1.1_amd64",
+		"main/arm64/docs_1.0_all",
+		"main/arm64/other_2.0_arm64",
+	}, listed)
+
+	shown, err := app.Show(ctx, PackageFilter{PackageName: "docs"})
+	assert.NoError(t, err)
+	assert.Len(t, shown, 2)
+	shown, err = app.Show(ctx, PackageFilter{PackageName: "tool", Version: "1.1"})
+	assert.NoError(t, err)
+	assert.Len(t, shown, 1)
+	assert.Equal(t, "1.1", shown[0].Record.Paragraph.Get("Version"))
+	_, err = app.Show(ctx, PackageFilter{PackageName: "tool", Version: "2.0"})
+	assert.ErrorIs(t, err, ErrPackageNotFound)
+	_, err = app.Show(ctx, PackageFilter{PackageName: "missing"})
+	assert.ErrorIs(t, err, ErrPackageNotFound)
+
+	filtered, err := app.List(ctx, PackageFilter{Architecture: "arm64"})
+	assert.NoError(t, err)
+	assert.Len(t, filtered, 2)
+	filtered, err = app.List(ctx, PackageFilter{Component: "contrib"})
+	assert.NoError(t, err)
+	assert.Empty(t, filtered)
+
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	removed, err := app.Remove(ctx, RemoveRequest{PackageName: "tool", Version: "1.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []deb.PackageKey{{PackageName: "tool", Version: "1.0", Architecture: "amd64"}}, removed)
+	removed, err = app.Remove(ctx, RemoveRequest{PackageName: "docs"})
+	assert.NoError(t, err)
+	assert.Equal(t, []deb.PackageKey{{PackageName: "docs", Version: "1.0", Architecture: "all"}}, removed, "removed from every index but reported once")
+	_, err = app.Remove(ctx, RemoveRequest{PackageName: "docs"})
+	assert.ErrorIs(t, err, ErrPackageNotFound)
+
+	entries, err = app.List(ctx, PackageFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// Indices and Release files stay consistent after removals
+	problems, err = app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// Reindex recovers a stale Packages.gz
+	memoryStorage.objects["dists/stable/main/binary-amd64/Packages.gz"] = []byte("stale")
+	problems, err = app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, problems, 1)
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{}))
+	problems, err = app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+// Test Verify reports indices and pool files that do not match the Release file and stanzas
+func TestVerifyProblems(t *testing.T) {
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+	})
+	ctx := context.Background()
+
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Problem{{Path: "dists/stable/Release", Message: "suite Release file is missing"}}, problems)
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	delete(memoryStorage.objects, "pool/main/t/tool/tool_1.0_amd64.deb")
+	delete(memoryStorage.objects, "dists/stable/main/binary-amd64/Release")
+	memoryStorage.objects["dists/stable/main/binary-i386/Packages"] = []byte{}
+
+	problems, err = app.Verify(ctx)
+	assert.NoError(t, err)
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	assert.Equal(t, []string{
+		"dists/stable/main/binary-amd64/Release: listed in the suite Release file but missing",
+		"dists/stable/main/binary-i386/Packages: not listed in the suite Release file",
+		"dists/stable/main/binary-amd64/Packages: tool_1.0_amd64 refers to missing pool file pool/main/t/tool/tool_1.0_amd64.deb",
+	}, messages)
+}
+
+// Test Remove selects stanzas by version and architecture and deletes pool files no suite refers to
+func TestRemove(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_1.0_arm64.deb": {PackageName: "tool", Version: "1.0", Architecture: "arm64"},
+		"docs_1.0_all.deb":   {PackageName: "docs", Version: "1.0", Architecture: "all"},
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name            string
+		request         RemoveRequest
+		expectedRemoved []string
+		expectedPool    map[string]bool
+	}{
+		{
+			name:            "one architecture",
+			request:         RemoveRequest{PackageName: "tool", Architecture: "arm64", DeletePool: true},
+			expectedRemoved: []string{"tool_1.0_arm64"},
+			expectedPool:    map[string]bool{"pool/main/t/tool/tool_1.0_amd64.deb": true, "pool/main/t/tool/tool_1.0_arm64.deb": false},
+		},
+		{
+			name:            "architecture all from one index keeps the pool file",
+			request:         RemoveRequest{PackageName: "docs", Architecture: "amd64", DeletePool: true},
+			expectedRemoved: []string{"docs_1.0_all"},
+			expectedPool:    map[string]bool{"pool/main/d/docs/docs_1.0_all.deb": true},
+		},
+		{
+			name:            "architecture all from every index",
+			request:         RemoveRequest{PackageName: "docs", Architecture: "all", DeletePool: true},
+			expectedRemoved: []string{"docs_1.0_all"},
+			expectedPool:    map[string]bool{"pool/main/d/docs/docs_1.0_all.deb": false},
+		},
+		{
+			name:            "pool is kept by default",
+			request:         RemoveRequest{PackageName: "tool", Version: "1.0"},
+			expectedRemoved: []string{"tool_1.0_amd64", "tool_1.0_arm64"},
+			expectedPool:    map[string]bool{"pool/main/t/tool/tool_1.0_amd64.deb": true, "pool/main/t/tool/tool_1.0_arm64.deb": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", Architectures: []string{"amd64", "arm64"}}, packages)
+			assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "tool_1.0_arm64.deb", "docs_1.0_all.deb"}))
+
+			removed, err := app.Remove(ctx, tt.request)
+			assert.NoError(t, err)
+			var keys []string
+			for _, key := range removed {
+				keys = append(keys, key.String())
+			}
+			assert.Equal(t, tt.expectedRemoved, keys)
+
+			for poolFile, expected := range tt.expectedPool {
+				_, found := memoryStorage.objects[poolFile]
+				assert.Equal(t, expected, found, poolFile)
+			}
+
+			problems, err := app.Verify(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, problems)
+		})
+	}
+}
+
+// Test Remove selects versions with each dpkg relation, in Debian version order
+func TestRemoveVersionConstraint(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.0~rc1_amd64.deb": {PackageName: "tool", Version: "1.0~rc1", Architecture: "amd64"},
+		"tool_1.0_amd64.deb":     {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_1.1_amd64.deb":     {PackageName: "tool", Version: "1.1", Architecture: "amd64"},
+		"tool_1.10_amd64.deb":    {PackageName: "tool", Version: "1.10", Architecture: "amd64"},
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		relation        deb.Relation
+		expectedRemoved []string
+	}{
+		{relation: deb.RelationEarlier, expectedRemoved: []string{"1.0~rc1", "1.0"}},
+		{relation: deb.RelationEarlierEqual, expectedRemoved: []string{"1.0~rc1", "1.0", "1.1"}},
+		{relation: deb.RelationEqual, expectedRemoved: []string{"1.1"}},
+		{relation: deb.RelationLaterEqual, expectedRemoved: []string{"1.1", "1.10"}},
+		{relation: deb.RelationLater, expectedRemoved: []string{"1.10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.relation), func(t *testing.T) {
+			app, _ := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+			assert.NoError(t, app.Publish(ctx, []string{"tool_1.0~rc1_amd64.deb", "tool_1.0_amd64.deb", "tool_1.1_amd64.deb", "tool_1.10_amd64.deb"}))
+
+			removed, err := app.Remove(ctx, RemoveRequest{PackageName: "tool", Relation: tt.relation, Version: "1.1"})
+			assert.NoError(t, err)
+			var versions []string
+			for _, key := range removed {
+				versions = append(versions, key.Version)
+			}
+			assert.Equal(t, tt.expectedRemoved, versions)
+
+			entries, err := app.List(ctx, PackageFilter{})
+			assert.NoError(t, err)
+			assert.Len(t, entries, len(packages)-len(tt.expectedRemoved))
+		})
+	}
+
+	app, _ := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "tool", Relation: deb.RelationLater, Version: "1.0"})
+	assert.ErrorIs(t, err, ErrPackageNotFound)
+}
+
+// Test Remove keeps a pool file that another suite still refers to
+func TestRemoveKeepsPoolFileOfOtherSuite(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+	}
+	ctx := context.Background()
+
+	stable, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	unstable := *stable
+	unstable.config = &Config{Archive: "unstable", Component: "main"}
+	assert.NoError(t, stable.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	assert.NoError(t, unstable.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+
+	_, err := stable.Remove(ctx, RemoveRequest{PackageName: "tool", DeletePool: true})
+	assert.NoError(t, err)
+	assert.Contains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.0_amd64.deb")
+
+	_, err = unstable.Remove(ctx, RemoveRequest{PackageName: "tool", DeletePool: true})
+	assert.NoError(t, err)
+	assert.NotContains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.0_amd64.deb")
+}
+
+// Test Remove deletes a pool file that only a kept by-hash generation of the index still refers to
+func TestRemoveDeletesPoolFileOfByHashGeneration(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_1.1_amd64.deb": {PackageName: "tool", Version: "1.1", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "tool_1.1_amd64.deb"}))
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "tool", Version: "1.0", DeletePool: true})
+	assert.NoError(t, err)
+
+	// The previous generation, kept by hash, still lists tool 1.0
+	generations, err := app.readByHashGenerations(ctx, "dists/stable/main/binary-amd64")
+	assert.NoError(t, err)
+	assert.Len(t, generations, 2)
+	assert.Contains(t, string(memoryStorage.objects[filepath.Join("dists/stable/main/binary-amd64", generations[0].Packages)]), "Version: 1.0\n")
+
+	assert.NotContains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.0_amd64.deb")
+	assert.Contains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.1_amd64.deb")
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+// Test Publish uploads a batch with one write per affected index and Release file
+func TestPublishBatch(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+		"cc_1.0_arm64.deb": {PackageName: "cc", Version: "1.0", Architecture: "arm64"},
+		"dd_1.0_all.deb":   {PackageName: "dd", Version: "1.0", Architecture: "all"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", Architectures: []string{"amd64", "arm64"}, Concurrency: 3}, packages)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb", "bb_1.0_amd64.deb", "cc_1.0_arm64.deb", "dd_1.0_all.deb"}))
+
+	for _, path := range []string{
+		"dists/stable/main/binary-amd64/Packages",
+		"dists/stable/main/binary-amd64/Packages.gz",
+		"dists/stable/main/binary-amd64/Release",
+		"dists/stable/main/binary-arm64/Packages",
+		"dists/stable/Release",
+	} {
+		assert.Equal(t, 1, memoryStorage.uploads[path], path)
+	}
+	assert.Equal(t, 1, memoryStorage.uploads["pool/main/a/aa/aa_1.0_amd64.deb"])
+
+	entries, err := app.List(ctx, PackageFilter{Architecture: "amd64"})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+// Test Publish checks the whole batch before uploading any of it
+func TestPublishBatchRejected(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb":  {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"aa_1.0_amd64b.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb":  {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+	}
+	ctx := context.Background()
+
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	err := app.Publish(ctx, []string{"bb_1.0_amd64.deb", "aa_1.0_amd64.deb", "aa_1.0_amd64b.deb"})
+	assert.ErrorContains(t, err, "are both aa_1.0_amd64")
+	assert.Empty(t, memoryStorage.objects)
+
+	// A package that conflicts with the index stops the batch under the fail policy
+	app, memoryStorage = newMemoryApplication(&Config{Archive: "stable", Component: "main", OnConflict: ConflictFail}, packages)
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	err = app.Publish(ctx, []string{"bb_1.0_amd64.deb", "aa_1.0_amd64b.deb"})
+	assert.ErrorIs(t, err, ErrPackageConflict)
+	assert.NotContains(t, memoryStorage.objects, "pool/main/b/bb/bb_1.0_amd64.deb")
+
+	// Under the skip policy the rest of the batch is still published
+	app, memoryStorage = newMemoryApplication(&Config{Archive: "stable", Component: "main", OnConflict: ConflictSkip}, packages)
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	assert.NoError(t, app.Publish(ctx, []string{"bb_1.0_amd64.deb", "aa_1.0_amd64b.deb"}))
+	assert.Contains(t, memoryStorage.objects, "pool/main/b/bb/bb_1.0_amd64.deb")
+	assert.Equal(t, 1, memoryStorage.uploads["pool/main/a/aa/aa_1.0_amd64.deb"])
+}
+
+// Test ExpandDebPaths accepts files, globs and directories
+func TestExpandDebPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a_1.0_amd64.deb", "b_1.0_amd64.deb", "notes.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	a := filepath.Join(dir, "a_1.0_amd64.deb")
+	b := filepath.Join(dir, "b_1.0_amd64.deb")
+
+	paths, err := ExpandDebPaths([]string{a, dir})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{a, b}, paths)
+
+	paths, err = ExpandDebPaths([]string{filepath.Join(dir, "b_*.deb")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{b}, paths)
+
+	_, err = ExpandDebPaths([]string{filepath.Join(dir, "c_*.deb")})
+	assert.Error(t, err)
+	_, err = ExpandDebPaths([]string{t.TempDir()})
+	assert.Error(t, err)
+}
+
+// Test a Packages index changed by another publisher between read and write is
+// re-read and merged instead of being overwritten
+func TestPublishConcurrentUpdate(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	ctx := context.Background()
+	packagesPath := "dists/stable/main/binary-amd64/Packages"
+
+	// Another job publishes bb before the first write of the index
+	concurrentWrites := 0
+	memoryStorage.beforeConditionalUpload = func(path string) {
+		if path == packagesPath && concurrentWrites == 0 {
+			concurrentWrites++
+			assert.NoError(t, memoryStorage.UploadBuffer(ctx, path, bytes.NewBufferString("Package: bb\nVersion: 1.0\nArchitecture: amd64\n")))
+		}
+	}
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+
+	entries, err := app.List(ctx, PackageFilter{})
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Record.Key.PackageName)
+	}
+	assert.ElementsMatch(t, []string{"aa", "bb"}, names)
+
+	// A writer that always wins exhausts the attempts
+	memoryStorage.beforeConditionalUpload = func(path string) {
+		if path != packagesPath {
+			return
+		}
+		concurrentWrites++
+		assert.NoError(t, memoryStorage.UploadBuffer(ctx, path, bytes.NewBufferString(fmt.Sprintf("Package: cc\nVersion: %d\nArchitecture: amd64\n", concurrentWrites))))
+	}
+	err = app.Publish(ctx, []string{"aa_1.0_amd64.deb"})
+	assert.ErrorIs(t, err, storage.ErrPreconditionFailed)
+	assert.Equal(t, 1+maxPackagesUpdateAttempts, concurrentWrites)
+}
+
+// Test a publisher whose index was rewritten by another one while it committed
+// never leaves compressed variants that disagree with Packages
+func TestPublishConcurrentVariants(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+	}
+	config := &Config{Archive: "stable", Component: "main", Compressions: []string{"", ".gz", ".xz"}}
+	app, memoryStorage := newMemoryApplication(config, packages)
+	other, _ := newMemoryApplication(config, packages)
+	other.storage = memoryStorage
+	ctx := context.Background()
+	indexDir := "dists/stable/main/binary-amd64/"
+
+	// The other publisher commits b after this one wrote Packages but before Packages.gz
+	memoryStorage.beforeConditionalUpload = func(path string) {
+		if path != indexDir+"Packages.gz" {
+			return
+		}
+		memoryStorage.beforeConditionalUpload = nil
+		items, err := other.preparePublishItems(ctx, []string{"bb_1.0_amd64.deb"})
+		assert.NoError(t, err)
+		assert.NoError(t, other.publishItems(ctx, items))
+	}
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+
+	packagesData := memoryStorage.objects[indexDir+"Packages"]
+	assert.Contains(t, string(packagesData), "Package: aa\n")
+	assert.Contains(t, string(packagesData), "Package: bb\n")
+	// Compression is deterministic, so matching variants are byte-identical
+	for _, suffix := range []string{".gz", ".xz"} {
+		expected, err := deb.Compress(packagesData, suffix)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.Bytes(), memoryStorage.objects[indexDir+"Packages"+suffix], "Packages"+suffix)
+	}
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+// Test publish takes the suite lock, refuses a live lock of another process and takes over a stale one
+func TestLock(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", LockOwner: "ci"}, packages)
+	ctx := context.Background()
+	lockPath := "dists/stable/.lock"
+
+	storeLock := func(lock Lock) {
+		data, err := json.Marshal(lock)
+		assert.NoError(t, err)
+		assert.NoError(t, memoryStorage.UploadBuffer(ctx, lockPath, bytes.NewBuffer(data)))
+	}
+
+	// The lock is held during publish and released afterwards
+	memoryStorage.beforeConditionalUpload = func(path string) {
+		if strings.HasSuffix(path, "/Packages") {
+			lock, err := app.LockStatus(ctx)
+			assert.NoError(t, err)
+			if assert.NotNil(t, lock) {
+				assert.Equal(t, "ci", lock.Owner)
+				assert.Equal(t, os.Getpid(), lock.PID)
+				assert.False(t, lock.Stale(time.Now()))
+			}
+		}
+	}
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	assert.NotContains(t, memoryStorage.objects, lockPath)
+	memoryStorage.beforeConditionalUpload = nil
+
+	// A live lock of another process
+	other := Lock{ID: "other", Owner: "job-2", Hostname: "runner", PID: 42, Expires: time.Now().Add(time.Minute)}
+	storeLock(other)
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "aa"})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, "job-2 on runner (pid 42)")
+
+	_, err = app.BreakLock(ctx, false)
+	assert.ErrorIs(t, err, ErrLocked)
+	broken, err := app.BreakLock(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", broken.ID)
+
+	lock, err := app.LockStatus(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, lock)
+
+	// A stale lock is taken over
+	other.Expires = time.Now().Add(-time.Second)
+	storeLock(other)
+	removed, err := app.Remove(ctx, RemoveRequest{PackageName: "aa"})
+	assert.NoError(t, err)
+	assert.Len(t, removed, 1)
+	assert.NotContains(t, memoryStorage.objects, lockPath)
+}
+
+// Test the lock on storage without conditional writes is written plainly and only
+// Test a lock object that cannot be parsed stops every command with ErrCorruptLock
+// until it is deleted with a forced BreakLock
+func TestCorruptLock(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	ctx := context.Background()
+	lockPath := "dists/stable/.lock"
+	memoryStorage.objects[lockPath] = []byte("\x00not json")
+
+	err := app.Publish(ctx, []string{"aa_1.0_amd64.deb"})
+	assert.ErrorIs(t, err, ErrCorruptLock)
+	assert.ErrorContains(t, err, "lock break --force")
+	_, err = app.GC(ctx, GCRequest{})
+	assert.ErrorIs(t, err, ErrCorruptLock)
+	_, err = app.LockStatus(ctx)
+	assert.ErrorIs(t, err, ErrCorruptLock)
+	_, err = app.BreakLock(ctx, false)
+	assert.ErrorIs(t, err, ErrCorruptLock)
+	assert.Contains(t, memoryStorage.objects, lockPath)
+
+	broken, err := app.BreakLock(ctx, true)
+	assert.NoError(t, err)
+	assert.True(t, broken.Corrupt)
+	assert.NotContains(t, memoryStorage.objects, lockPath)
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+}
+
+// held when it reads back as this process's own after the settle delay
+func TestLockWithoutConditionalWrites(t *testing.T) {
+	defer func(delay time.Duration) { lockSettleDelay = delay }(lockSettleDelay)
+	lockSettleDelay = 10 * time.Millisecond
+
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", LockOwner: "ci", NoConditionalWrites: true}, packages)
+	memoryStorage.noConditionalWrites = true
+	ctx := context.Background()
+	lockPath := "dists/stable/.lock"
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	assert.Contains(t, memoryStorage.objects, "dists/stable/main/binary-amd64/Packages")
+	assert.NotContains(t, memoryStorage.objects, lockPath)
+
+	// Another process that also saw the suite unlocked overwrites the lock right after it was written
+	other, err := json.Marshal(Lock{ID: "other", Owner: "job-2", Hostname: "runner", PID: 42, Expires: time.Now().Add(time.Minute)})
+	assert.NoError(t, err)
+	memoryStorage.afterUpload = func(path string) {
+		if path == lockPath {
+			memoryStorage.afterUpload = nil
+			memoryStorage.objects[lockPath] = other
+		}
+	}
+	err = app.Publish(ctx, []string{"bb_1.0_amd64.deb"})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, "job-2 on runner (pid 42)")
+	assert.NotContains(t, string(memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]), "Package: bb\n")
+	assert.Equal(t, other, memoryStorage.objects[lockPath], "the lock of the other process is left alone")
+
+	// The heartbeat reads the lock back after renewing it and gives up once it was taken over
+	mine := &Lock{ID: "mine", Owner: "ci"}
+	assert.NoError(t, app.writeLock(ctx, mine, ""))
+	assert.NoError(t, app.heartbeat(ctx, mine))
+	assert.True(t, mine.Expires.After(time.Now().Add(MinLockTTL)), "the lease is at least the minimum")
+	memoryStorage.afterUpload = func(path string) {
+		if path == lockPath {
+			memoryStorage.afterUpload = nil
+			memoryStorage.objects[lockPath] = other
+		}
+	}
+	assert.ErrorIs(t, app.heartbeat(ctx, mine), errLockLost)
+	assert.ErrorIs(t, app.heartbeat(ctx, mine), errLockLost, "a lock held by another process is not renewed")
+	assert.Equal(t, other, memoryStorage.objects[lockPath])
+}
+
+// Test Publish starts from the newest by-hash generation or a compressed variant of an
+// index whose plain Packages file is gone, and fails rather than start empty when none
+// of them can be read
+func TestPublishIndexWithoutPackages(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+		"cc_1.0_amd64.deb": {PackageName: "cc", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	ctx := context.Background()
+	packagesPath := "dists/stable/main/binary-amd64/Packages"
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	delete(memoryStorage.objects, packagesPath)
+	assert.NoError(t, app.Publish(ctx, []string{"bb_1.0_amd64.deb"}))
+	assert.Contains(t, string(memoryStorage.objects[packagesPath]), "Package: aa\n")
+	assert.Contains(t, string(memoryStorage.objects[packagesPath]), "Package: bb\n")
+
+	// Without by-hash copies the stanzas come from Packages.gz
+	delete(memoryStorage.objects, packagesPath)
+	for path := range memoryStorage.objects {
+		if strings.Contains(path, "/by-hash/") {
+			delete(memoryStorage.objects, path)
+		}
+	}
+	assert.NoError(t, app.Publish(ctx, []string{"cc_1.0_amd64.deb"}))
+	for _, name := range []string{"aa", "bb", "cc"} {
+		assert.Contains(t, string(memoryStorage.objects[packagesPath]), "Package: "+name+"\n")
+	}
+
+	delete(memoryStorage.objects, packagesPath)
+	memoryStorage.objects[packagesPath+".gz"] = []byte("not gzip")
+	for path := range memoryStorage.objects {
+		if strings.Contains(path, "/by-hash/") {
+			delete(memoryStorage.objects, path)
+		}
+	}
+	assert.ErrorIs(t, app.Publish(ctx, []string{"cc_1.0_amd64.deb"}), errInvalidPackagesIndex)
+	assert.NotContains(t, memoryStorage.objects, packagesPath)
+}
+
+// Test indices are written by hash first, then under their plain names, and the suite Release files last
+func TestPublishStagedOrder(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_all.deb":   {PackageName: "bb", Version: "1.0", Architecture: "all"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", Architectures: []string{"amd64", "arm64"}}, packages)
+	signer := new(MockSigner)
+	signer.On("ClearSign", mock.Anything).Return([]byte("clearsigned"), nil)
+	signer.On("DetachSign", mock.Anything).Return([]byte("signature"), nil)
+	app.signer = signer
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb", "bb_1.0_all.deb"}))
+
+	var metadata []string
+	for _, path := range memoryStorage.uploadOrder {
+		if strings.HasPrefix(path, "dists/") && !strings.HasSuffix(path, "/.lock") && !strings.HasSuffix(path, "/generations.json") {
+			metadata = append(metadata, path)
+		}
+	}
+	lastByHash := -1
+	firstPlain := len(metadata)
+	for i, path := range metadata {
+		if strings.Contains(path, "/by-hash/") {
+			lastByHash = i
+		} else if i < firstPlain {
+			firstPlain = i
+		}
+	}
+	assert.Less(t, lastByHash, firstPlain)
+	assert.Equal(t, []string{"dists/stable/Release", "dists/stable/Release.gpg", "dists/stable/InRelease"}, metadata[len(metadata)-3:])
+
+	// Every index listed in the suite Release file is also stored by hash
+	assertByHashView(t, memoryStorage)
+}
+
+// Test a publish that fails half-way leaves the previous suite Release file and the indices it lists by hash intact
+func TestPublishFailureKeepsPreviousView(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_all.deb":   {PackageName: "bb", Version: "1.0", Architecture: "all"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", Architectures: []string{"amd64", "arm64"}}, packages)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	release := bytes.Clone(memoryStorage.objects["dists/stable/Release"])
+
+	memoryStorage.failUpload = func(path string) error {
+		if path == "dists/stable/main/binary-arm64/Packages.gz" {
+			return fmt.Errorf("connection reset")
+		}
+		return nil
+	}
+	assert.ErrorContains(t, app.Publish(ctx, []string{"bb_1.0_all.deb"}), "connection reset")
+
+	assert.Equal(t, release, memoryStorage.objects["dists/stable/Release"])
+	assertByHashView(t, memoryStorage)
+}
+
+// assertByHashView checks that every index listed in the SHA256 section of the suite
+// Release file can be fetched by hash with the listed contents.
+func assertByHashView(t *testing.T, memoryStorage *MemoryStorage) {
+	paragraphs, err := deb822.Parse(bytes.NewReader(memoryStorage.objects["dists/stable/Release"]))
+	assert.NoError(t, err)
+	listed, err := deb.ParseChecksums(paragraphs[0].Get("SHA256"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, listed)
+
+	for _, entry := range listed {
+		path := filepath.Join("dists/stable", filepath.Dir(entry.Filename), "by-hash/SHA256", entry.Checksum)
+		data, found := memoryStorage.objects[path]
+		if assert.True(t, found, path) {
+			assert.Equal(t, entry.Checksum, deb.ComputeChecksums(data).SHA256, path)
+		}
+	}
+}
+
+// Test Acquire-By-Hash is only advertised once every listed index has by-hash copies
+func TestAcquireByHashWithPlainIndex(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	ctx := context.Background()
+
+	// An index published before by-hash copies were kept
+	memoryStorage.objects["dists/stable/main/binary-arm64/Packages"] = []byte("Package: bb\nVersion: 1.0\nArchitecture: arm64\nFilename: pool/main/b/bb/bb_1.0_arm64.deb\n")
+	memoryStorage.modified["dists/stable/main/binary-arm64/Packages"] = time.Now()
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	release := string(memoryStorage.objects["dists/stable/Release"])
+	assert.Contains(t, release, " main/binary-arm64/Packages\n")
+	assert.NotContains(t, release, "Acquire-By-Hash")
+
+	// Rewriting every index stores its by-hash copies
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{}))
+	assert.Contains(t, string(memoryStorage.objects["dists/stable/Release"]), "Acquire-By-Hash: yes\n")
+	assertByHashView(t, memoryStorage)
+}
+
+// Test by-hash objects are kept for the configured number of generations of an index
+func TestByHashPruning(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+		"cc_1.0_amd64.deb": {PackageName: "cc", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", ByHashGenerations: 2}, packages)
+	ctx := context.Background()
+	byHashDir := "dists/stable/main/binary-amd64/by-hash/SHA256/"
+
+	byHashObjects := func() []string {
+		var paths []string
+		for path := range memoryStorage.objects {
+			if strings.HasPrefix(path, byHashDir) {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	first := byHashObjects()
+	assert.Len(t, first, 3)
+	assert.Contains(t, string(memoryStorage.objects["dists/stable/Release"]), "Acquire-By-Hash: yes\n")
+
+	assert.NoError(t, app.Publish(ctx, []string{"bb_1.0_amd64.deb"}))
+	assert.Len(t, byHashObjects(), 6)
+
+	// The third generation prunes the first
+	assert.NoError(t, app.Publish(ctx, []string{"cc_1.0_amd64.deb"}))
+	remaining := byHashObjects()
+	assert.Len(t, remaining, 6)
+	for _, path := range first {
+		assert.NotContains(t, remaining, path)
+	}
+	assertByHashView(t, memoryStorage)
+}
+
+// Test every configured Packages variant is written and listed in both Release levels
+func TestPublishCompressions(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+	}
+	config := &Config{Archive: "stable", Component: "main", Compressions: []string{".gz", ".xz", ".bz2", ".zst"}}
+	app, memoryStorage := newMemoryApplication(config, packages)
+	ctx := context.Background()
+	indexDir := "dists/stable/main/binary-amd64/"
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+
+	packageRelease := string(memoryStorage.objects[indexDir+"Release"])
+	suiteRelease := string(memoryStorage.objects["dists/stable/Release"])
+	for _, name := range []string{"Packages.gz", "Packages.xz", "Packages.bz2", "Packages.zst"} {
+		data, ok := memoryStorage.objects[indexDir+name]
+		if !assert.True(t, ok, name) {
+			continue
+		}
+		checksums := deb.ComputeChecksums(data)
+		assert.Contains(t, packageRelease, fmt.Sprintf(" %s %d %s\n", checksums.SHA256, checksums.Size, name))
+		assert.Contains(t, suiteRelease, fmt.Sprintf(" %s %d main/binary-amd64/%s\n", checksums.SHA256, checksums.Size, name))
+	}
+
+	// The uncompressed index is still written to read it back, but not listed
+	assert.Contains(t, memoryStorage.objects, indexDir+"Packages")
+	assert.NotContains(t, packageRelease, " Packages\n")
+	assert.NotContains(t, suiteRelease, "binary-amd64/Packages\n")
+
+	gzReader, err := gzip.NewReader(bytes.NewReader(memoryStorage.objects[indexDir+"Packages.gz"]))
+	assert.NoError(t, err)
+	packagesData, err := io.ReadAll(gzReader)
+	assert.NoError(t, err)
+	assert.Equal(t, memoryStorage.objects[indexDir+"Packages"], packagesData)
+
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// Variants dropped from the configuration are deleted when the index is rewritten
+	config.Compressions = []string{"", ".xz"}
+	assert.NoError(t, app.Publish(ctx, []string{"bb_1.0_amd64.deb"}))
+	assert.NotContains(t, memoryStorage.objects, indexDir+"Packages.gz")
+	assert.NotContains(t, memoryStorage.objects, indexDir+"Packages.zst")
+	assert.Contains(t, string(memoryStorage.objects[indexDir+"Release"]), " Packages\n")
+	assert.Contains(t, string(memoryStorage.objects[indexDir+"Release"]), " Packages.xz\n")
+	assert.NotContains(t, string(memoryStorage.objects["dists/stable/Release"]), "Packages.gz")
+	assertByHashView(t, memoryStorage)
+}
+
+// Test publishing the same packages in a different order gives byte-identical metadata
+func TestPublishReproducible(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.10_amd64.deb":     {PackageName: "tool", Version: "1.10", Architecture: "amd64"},
+		"tool_1.10~rc1_amd64.deb": {PackageName: "tool", Version: "1.10~rc1", Architecture: "amd64"},
+		"tool_1.9_amd64.deb":      {PackageName: "tool", Version: "1.9", Architecture: "amd64"},
+		"lib_1.0_arm64.deb":       {PackageName: "lib", Version: "1.0", Architecture: "arm64"},
+	}
+	date := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	ctx := context.Background()
+
+	publish := func(batches ...[]string) map[string][]byte {
+		app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main", Date: date}, packages)
+		for _, batch := range batches {
+			assert.NoError(t, app.Publish(ctx, batch))
+		}
+		metadata := make(map[string][]byte)
+		for path, data := range memoryStorage.objects {
+			if strings.HasPrefix(path, "dists/") && !strings.Contains(path, "/by-hash/") {
+				metadata[path] = data
+			}
+		}
+		return metadata
+	}
+
+	first := publish([]string{"tool_1.9_amd64.deb", "tool_1.10_amd64.deb"}, []string{"tool_1.10~rc1_amd64.deb", "lib_1.0_arm64.deb"})
+	second := publish([]string{"lib_1.0_arm64.deb"}, []string{"tool_1.10_amd64.deb", "tool_1.10~rc1_amd64.deb", "tool_1.9_amd64.deb"})
+	assert.Equal(t, first, second)
+
+	release := string(first["dists/stable/Release"])
+	assert.Contains(t, release, "Architectures: amd64 arm64\n")
+	assert.Contains(t, release, "Date: Tue, 02 Jan 2024 03:04:05 UTC\n")
+
+	records, err := deb.ParsePackagesFile(string(first["dists/stable/main/binary-amd64/Packages"]))
+	assert.NoError(t, err)
+	var versions []string
+	for _, record := range records {
+		versions = append(versions, record.Key.Version)
+	}
+	assert.Equal(t, []string{"1.9", "1.10~rc1", "1.10"}, versions)
+}
+
+// Test Publish keeps only the newest versions of each package when a retention policy is set
+func TestPublishRetention(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.9_amd64.deb":      {PackageName: "tool", Version: "1.9", Architecture: "amd64"},
+		"tool_1.10~rc1_amd64.deb": {PackageName: "tool", Version: "1.10~rc1", Architecture: "amd64"},
+		"tool_1.10_amd64.deb":     {PackageName: "tool", Version: "1.10", Architecture: "amd64"},
+		"tool_1.8_amd64.deb":      {PackageName: "tool", Version: "1.8", Architecture: "amd64"},
+		"lib_1.0_amd64.deb":       {PackageName: "lib", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "nightly", Component: "main", Retention: Retention{KeepVersions: 2}}, packages)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.10_amd64.deb", "lib_1.0_amd64.deb"}))
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.9_amd64.deb"}))
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.10~rc1_amd64.deb"}))
+
+	entries, err := app.List(ctx, PackageFilter{})
+	assert.NoError(t, err)
+	var listed []string
+	for _, entry := range entries {
+		listed = append(listed, entry.Record.Key.String())
+	}
+	assert.Equal(t, []string{"lib_1.0_amd64", "tool_1.10~rc1_amd64", "tool_1.10_amd64"}, listed)
+
+	// Pool files are left for garbage collection
+	assert.Contains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.9_amd64.deb")
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// A version older than the newest ones is still published; the next prune drops it
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.8_amd64.deb"}))
+	shown, err := app.Show(ctx, PackageFilter{PackageName: "tool", Version: "1.8"})
+	assert.NoError(t, err)
+	assert.Len(t, shown, 1)
+	pruned, err := app.Prune(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []deb.PackageKey{{PackageName: "tool", Version: "1.8", Architecture: "amd64"}}, pruned)
+}
+
+// Test Prune drops versions older than the maximum age but keeps the newest version of each package
+func TestPrune(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_2.0_amd64.deb": {PackageName: "tool", Version: "2.0", Architecture: "amd64"},
+		"tool_3.0_amd64.deb": {PackageName: "tool", Version: "3.0", Architecture: "amd64"},
+		"lib_1.0_all.deb":    {PackageName: "lib", Version: "1.0", Architecture: "all"},
+	}
+	config := &Config{Archive: "nightly", Component: "main", Architectures: []string{"amd64"}}
+	app, memoryStorage := newMemoryApplication(config, packages)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "tool_2.0_amd64.deb", "tool_3.0_amd64.deb", "lib_1.0_all.deb"}))
+
+	_, err := app.Prune(ctx, false)
+	assert.ErrorIs(t, err, ErrNoRetention)
+
+	// Everything but tool 3.0 was uploaded two days ago
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{"pool/main/t/tool/tool_1.0_amd64.deb", "pool/main/t/tool/tool_2.0_amd64.deb", "pool/main/l/lib/lib_1.0_all.deb"} {
+		memoryStorage.modified[path] = twoDaysAgo
+	}
+	config.Retention = Retention{MaxAge: 24 * time.Hour}
+
+	pruned, err := app.Prune(ctx, true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []deb.PackageKey{
+		{PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		{PackageName: "tool", Version: "2.0", Architecture: "amd64"},
+	}, pruned)
+	assert.NotContains(t, memoryStorage.objects, "pool/main/t/tool/tool_1.0_amd64.deb")
+	assert.NotContains(t, memoryStorage.objects, "pool/main/t/tool/tool_2.0_amd64.deb")
+	assert.Contains(t, memoryStorage.objects, "pool/main/l/lib/lib_1.0_all.deb")
+
+	entries, err := app.List(ctx, PackageFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// A second run has nothing left to prune and writes nothing
+	uploads := len(memoryStorage.uploadOrder)
+	pruned, err = app.Prune(ctx, true)
+	assert.NoError(t, err)
+	assert.Empty(t, pruned)
+	assert.Equal(t, uploads+1, len(memoryStorage.uploadOrder), "only the lock is written")
+
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+// Test the retention policy stored for a component is applied by every publish and
+// prune of that component, unless the run has a retention policy of its own
+func TestStoredRetention(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"tool_1.0_amd64.deb": {PackageName: "tool", Version: "1.0", Architecture: "amd64"},
+		"tool_2.0_amd64.deb": {PackageName: "tool", Version: "2.0", Architecture: "amd64"},
+		"tool_3.0_amd64.deb": {PackageName: "tool", Version: "3.0", Architecture: "amd64"},
+	}
+	config := &Config{Archive: "nightly", Component: "main"}
+	app, memoryStorage := newMemoryApplication(config, packages)
+	ctx := context.Background()
+
+	_, err := app.UpdatePolicy(ctx, func(policy *RepositoryPolicy) {
+		policy.SetComponentRetention("nightly", "main", Retention{KeepVersions: 1, MaxAge: 720 * time.Hour})
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, string(memoryStorage.objects[policyPath]), `"nightly/main": {
+      "keepVersions": 1,
+      "maxAge": "720h0m0s"
+    }`)
+	stored, err := app.StoredPolicy(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Retention{KeepVersions: 1, MaxAge: 720 * time.Hour}, stored.ComponentRetention("nightly", "main"))
+
+	versions := func() []string {
+		entries, err := app.List(ctx, PackageFilter{Component: "main"})
+		assert.NoError(t, err)
+		var versions []string
+		for _, entry := range entries {
+			versions = append(versions, entry.Record.Key.Version)
+		}
+		return versions
+	}
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	assert.NoError(t, app.Publish(ctx, []string{"tool_2.0_amd64.deb"}))
+	assert.Equal(t, []string{"2.0"}, versions())
+
+	// Another component of the suite has no stored retention policy
+	config.Component = "contrib"
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "tool_2.0_amd64.deb"}))
+	_, err = app.Prune(ctx, false)
+	assert.ErrorIs(t, err, ErrNoRetention)
+
+	// The retention policy of the run replaces the stored one
+	config.Component = "main"
+	config.Retention = Retention{KeepVersions: 2}
+	assert.NoError(t, app.Publish(ctx, []string{"tool_3.0_amd64.deb"}))
+	assert.ElementsMatch(t, []string{"2.0", "3.0"}, versions())
+
+	config.Retention = Retention{}
+	pruned, err := app.Prune(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []deb.PackageKey{{PackageName: "tool", Version: "2.0", Architecture: "amd64"}}, pruned)
+
+	// A retention policy that drops nothing is removed
+	_, err = app.UpdatePolicy(ctx, func(policy *RepositoryPolicy) {
+		policy.SetComponentRetention("nightly", "main", Retention{})
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(memoryStorage.objects[policyPath]), "retention")
+	_, err = app.Prune(ctx, false)
+	assert.ErrorIs(t, err, ErrNoRetention)
+}
+
+// Test GC deletes the pool files no index or kept by-hash generation refers to once
+// they are older than the grace period, and waits for the suite lock
+func TestGC(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+		"bb_1.0_amd64.deb": {PackageName: "bb", Version: "1.0", Architecture: "amd64"},
+		"cc_1.0_amd64.deb": {PackageName: "cc", Version: "1.0", Architecture: "amd64"},
+		"dd_1.0_amd64.deb": {PackageName: "dd", Version: "1.0", Architecture: "amd64"},
+	}
+	config := &Config{Archive: "stable", Component: "main", ByHashGenerations: 2}
+	app, memoryStorage := newMemoryApplication(config, packages)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb", "bb_1.0_amd64.deb"}))
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "bb"})
+	assert.NoError(t, err)
+	config.Archive = "nightly"
+	assert.NoError(t, app.Publish(ctx, []string{"cc_1.0_amd64.deb"}))
+	config.Archive = "stable"
+
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, "pool/main/o/old/old_1.0_amd64.deb", bytes.NewBufferString("old")))
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, "pool/main/n/new/new_1.0_amd64.deb", bytes.NewBufferString("new")))
+	ageFiles := func() {
+		for path := range memoryStorage.objects {
+			if strings.HasPrefix(path, "pool/") && path != "pool/main/n/new/new_1.0_amd64.deb" {
+				memoryStorage.modified[path] = twoDaysAgo
+			}
+		}
+	}
+	ageFiles()
+	collectedKeys := func(objects []storage.ObjectInfo) []string {
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+
+	// bb was removed from stable, but the generation before the removal still lists it
+	expected := []string{"pool/main/o/old/old_1.0_amd64.deb"}
+	collected, err := app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, collectedKeys(collected))
+	assert.Contains(t, memoryStorage.objects, "pool/main/o/old/old_1.0_amd64.deb")
+
+	collected, err = app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, collectedKeys(collected))
+	assert.NotContains(t, memoryStorage.objects, "pool/main/o/old/old_1.0_amd64.deb")
+	assert.Contains(t, memoryStorage.objects, "pool/main/b/bb/bb_1.0_amd64.deb")
+
+	// Once a newer publish drops that generation, bb is collected
+	assert.NoError(t, app.Publish(ctx, []string{"dd_1.0_amd64.deb"}))
+	ageFiles()
+	collected, err = app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pool/main/b/bb/bb_1.0_amd64.deb"}, collectedKeys(collected))
+	for _, path := range []string{"pool/main/a/aa/aa_1.0_amd64.deb", "pool/main/c/cc/cc_1.0_amd64.deb", "pool/main/d/dd/dd_1.0_amd64.deb", "pool/main/n/new/new_1.0_amd64.deb"} {
+		assert.Contains(t, memoryStorage.objects, path)
+	}
+	assert.NotContains(t, memoryStorage.objects, "dists/stable/.lock")
+
+	// A suite locked by another process is not collected from
+	other, err := json.Marshal(Lock{ID: "other", Owner: "job-2", Hostname: "runner", PID: 42, Expires: time.Now().Add(time.Minute)})
+	assert.NoError(t, err)
+	memoryStorage.objects["dists/stable/.lock"] = other
+	_, err = app.GC(ctx, GCRequest{})
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, memoryStorage.objects, "pool/main/n/new/new_1.0_amd64.deb")
+
+	// A dry run deletes nothing, so it neither waits for nor writes the lock
+	uploads := memoryStorage.uploads["dists/stable/.lock"]
+	_, err = app.GC(ctx, GCRequest{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, other, memoryStorage.objects["dists/stable/.lock"])
+	assert.Equal(t, uploads, memoryStorage.uploads["dists/stable/.lock"])
+}
+
+// Test GC keeps the pool files of an index whose plain Packages file is gone, reading
+// them from its compressed variants or by-hash generations, and stops when none of the
+// files of an index directory can be read
+func TestGCIndexWithoutPackages(t *testing.T) {
+	packages := map[string]*deb.PackageMetadata{
+		"aa_1.0_amd64.deb": {PackageName: "aa", Version: "1.0", Architecture: "amd64"},
+	}
+	app, memoryStorage := newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	ctx := context.Background()
+	dir := "dists/stable/main/binary-amd64"
+	poolPath := "pool/main/a/aa/aa_1.0_amd64.deb"
+
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	memoryStorage.modified[poolPath] = time.Now().Add(-48 * time.Hour)
+
+	// Only Packages.gz is left
+	delete(memoryStorage.objects, dir+"/Packages")
+	for path := range memoryStorage.objects {
+		if strings.Contains(path, "/by-hash/") {
+			delete(memoryStorage.objects, path)
+		}
+	}
+	collected, err := app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Empty(t, collected)
+
+	// Only the by-hash generations are left
+	app, memoryStorage = newMemoryApplication(&Config{Archive: "stable", Component: "main"}, packages)
+	assert.NoError(t, app.Publish(ctx, []string{"aa_1.0_amd64.deb"}))
+	memoryStorage.modified[poolPath] = time.Now().Add(-48 * time.Hour)
+	delete(memoryStorage.objects, dir+"/Packages")
+	delete(memoryStorage.objects, dir+"/Packages.gz")
+	collected, err = app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Empty(t, collected)
+	assert.Contains(t, memoryStorage.objects, poolPath)
+
+	// Files that cannot be read stop the collection instead of emptying the index
+	for path := range memoryStorage.objects {
+		if strings.Contains(path, "/by-hash/SHA") {
+			memoryStorage.objects[path] = []byte("garbage")
+		}
+	}
+	_, err = app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour})
+	assert.ErrorIs(t, err, errInvalidPackagesIndex)
+	assert.Contains(t, memoryStorage.objects, poolPath)
+}
+
+// buildDeb returns a .deb holding control as its control file and an empty data tarball.
+func buildDeb(t *testing.T, control string) []byte {
+	tarball := func(files map[string]string) []byte {
+		var buffer bytes.Buffer
+		gzipWriter := gzip.NewWriter(&buffer)
+		tarWriter := tar.NewWriter(gzipWriter)
+		for name, content := range files {
+			assert.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+			_, err := tarWriter.Write([]byte(content))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, tarWriter.Close())
+		assert.NoError(t, gzipWriter.Close())
+		return buffer.Bytes()
+	}
+
+	var buffer bytes.Buffer
+	arWriter := ar.NewWriter(&buffer)
+	assert.NoError(t, arWriter.WriteGlobalHeader())
+	members := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "debian-binary", content: []byte("2.0\n")},
+		{name: "control.tar.gz", content: tarball(map[string]string{"./control": control})},
+		{name: "data.tar.gz", content: tarball(nil)},
+	}
+	for _, member := range members {
+		assert.NoError(t, arWriter.WriteHeader(&ar.Header{Name: member.name, Mode: 0o644, Size: int64(len(member.content))}))
+		_, err := arWriter.Write(member.content)
+		assert.NoError(t, err)
+	}
+	return buffer.Bytes()
+}
+
+// debControl returns the control file of a test package.
+func debControl(name, version, architecture, description string) string {
+	return fmt.Sprintf("Package: %s\nVersion: %s\nArchitecture: %s\nMaintainer: Test <test@example.com>\nDescription: %s\n", name, version, architecture, description)
+}
+
+// countingExtractor is the real deb.Extractor that counts the packages it reads.
+type countingExtractor struct {
+	deb.Extractor
+	mu    sync.Mutex
+	reads int
+}
+
+func (c *countingExtractor) ExtractPackageMetadata(file io.Reader) (*deb.PackageMetadata, error) {
+	c.mu.Lock()
+	c.reads++
+	c.mu.Unlock()
+	return c.Extractor.ExtractPackageMetadata(file)
+}
+
+// newDebApplication returns an application on MemoryStorage that publishes the .deb
+// files in debs, by path, with the real extractor.
+func newDebApplication(config *Config, debs map[string][]byte) (*applicationImpl, *MemoryStorage, *countingExtractor) {
+	logger := log.NewEntry(log.New())
+	mockFileReader := new(MockFileReader)
+	for path, data := range debs {
+		mockFileReader.On("Open", path).Return(&BytesFile{Reader: bytes.NewReader(data)}, nil)
+	}
+	extractor := &countingExtractor{Extractor: deb.New(logger)}
+
+	memoryStorage := NewMemoryStorage()
+	return &applicationImpl{
+		logger:     logger,
+		storage:    memoryStorage,
+		fileReader: mockFileReader,
+		extractor:  extractor,
+		config:     config,
+	}, memoryStorage, extractor
+}
+
+// Test Reindex rebuilds deleted and corrupt Packages indices from the .deb files in
+// the pool they refer to, using the cache for unchanged files, leaves them alone when
+// a pool file cannot be read, and only indexes the whole pool when asked to
+func TestReindexFromPool(t *testing.T) {
+	debs := map[string][]byte{
+		"tool_1.0_amd64.deb":  buildDeb(t, debControl("tool", "1.0", "amd64", "A tool")),
+		"tool_1.1_amd64.deb":  buildDeb(t, debControl("tool", "1.1", "amd64", "A tool")),
+		"docs_1.0_all.deb":    buildDeb(t, debControl("docs", "1.0", "all", "Documentation")),
+		"other_2.0_arm64.deb": buildDeb(t, debControl("other", "2.0", "arm64", "Another tool")),
+		"gone_1.0_amd64.deb":  buildDeb(t, debControl("gone", "1.0", "amd64", "A removed tool")),
+		"beta_1.0_amd64.deb":  buildDeb(t, debControl("beta", "1.0", "amd64", "A tool in testing")),
+	}
+	config := &Config{Archive: "stable", Component: "main", Concurrency: 2}
+	app, memoryStorage, extractor := newDebApplication(config, debs)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, app.Reindex(ctx, ReindexRequest{FromPool: true}), errNoPoolFiles, "an empty pool does not empty the indices")
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "tool_1.1_amd64.deb", "other_2.0_arm64.deb", "gone_1.0_amd64.deb"}))
+	assert.NoError(t, app.Publish(ctx, []string{"docs_1.0_all.deb"}))
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "gone"})
+	assert.NoError(t, err)
+	// Another suite shares the pool
+	config.Archive = "testing"
+	assert.NoError(t, app.Publish(ctx, []string{"beta_1.0_amd64.deb"}))
+	config.Archive = "stable"
+
+	extractor.reads = 0
+	amd64Packages := memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]
+	arm64Packages := memoryStorage.objects["dists/stable/main/binary-arm64/Packages"]
+	testingPackages := memoryStorage.objects["dists/testing/main/binary-amd64/Packages"]
+	assert.Contains(t, string(amd64Packages), "Package: docs\n")
+	assert.NotContains(t, string(amd64Packages), "Package: gone\n")
+	brokenPath := "pool/main/b/broken/broken_1.0_amd64.deb"
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, brokenPath, bytes.NewBufferString("not a package")))
+
+	delete(memoryStorage.objects, "dists/stable/main/binary-amd64/Packages")
+	delete(memoryStorage.objects, "dists/stable/main/binary-amd64/Packages.gz")
+	memoryStorage.objects["dists/stable/main/binary-arm64/Packages"] = []byte("Package: other\n")
+	assert.ErrorIs(t, app.Reindex(ctx, ReindexRequest{}), errInvalidPackagesIndex)
+
+	// Only the files of the suite are read, found through the newest by-hash generation
+	// of the missing and corrupt indices; gone, beta and the broken file are not
+	cachePath := filepath.Join(t.TempDir(), "cache", "pool.json")
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, CachePath: cachePath}))
+	assert.Equal(t, string(amd64Packages), string(memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]))
+	assert.Equal(t, string(arm64Packages), string(memoryStorage.objects["dists/stable/main/binary-arm64/Packages"]))
+	assert.Equal(t, string(testingPackages), string(memoryStorage.objects["dists/testing/main/binary-amd64/Packages"]))
+	assert.Equal(t, 4, extractor.reads)
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// Unchanged files come from the cache; a replaced file is read again
+	rebuilt := buildDeb(t, debControl("tool", "1.1", "amd64", "A rebuilt tool"))
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, "pool/main/t/tool/tool_1.1_amd64.deb", bytes.NewBuffer(rebuilt)))
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, CachePath: cachePath}))
+	assert.Equal(t, 5, extractor.reads, "only the replaced file is read again")
+	shown, err := app.Show(ctx, PackageFilter{PackageName: "tool", Version: "1.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "A rebuilt tool", shown[0].Record.Paragraph.Get("Description"))
+	assert.Equal(t, strconv.Itoa(len(rebuilt)), shown[0].Record.Paragraph.Get("Size"))
+	problems, err = app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// A pool file that cannot be read would be dropped from the indices, so nothing is written
+	amd64Packages = memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]
+	toolPath := "pool/main/t/tool/tool_1.0_amd64.deb"
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, toolPath, bytes.NewBufferString("not a package")))
+	assert.ErrorContains(t, app.Reindex(ctx, ReindexRequest{FromPool: true}), toolPath)
+	assert.Equal(t, amd64Packages, memoryStorage.objects["dists/stable/main/binary-amd64/Packages"])
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, toolPath, bytes.NewBuffer(debs["tool_1.0_amd64.deb"])))
+
+	// The whole pool includes the packages of other suites and removed ones
+	assert.ErrorContains(t, app.Reindex(ctx, ReindexRequest{FromPool: true, WholePool: true}), brokenPath)
+	delete(memoryStorage.objects, brokenPath)
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, WholePool: true}))
+	amd64Packages = memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]
+	for _, name := range []string{"tool", "docs", "gone", "beta"} {
+		assert.Contains(t, string(amd64Packages), "Package: "+name+"\n")
+	}
+	problems, err = app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+// Test Reindex reads the pool files of an index whose plain Packages file is gone, and
+// that has no by-hash generation, from its compressed variants, and refuses to rebuild
+// when none of them can be read
+func TestReindexFromPoolCompressedIndex(t *testing.T) {
+	debs := map[string][]byte{
+		"tool_1.0_amd64.deb": buildDeb(t, debControl("tool", "1.0", "amd64", "A tool")),
+		"gone_1.0_amd64.deb": buildDeb(t, debControl("gone", "1.0", "amd64", "A removed tool")),
+	}
+	config := &Config{Archive: "stable", Component: "main", Compressions: []string{".xz"}}
+	app, memoryStorage, _ := newDebApplication(config, debs)
+	ctx := context.Background()
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb", "gone_1.0_amd64.deb"}))
+	_, err := app.Remove(ctx, RemoveRequest{PackageName: "gone"})
+	assert.NoError(t, err)
+	amd64Packages := memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]
+	// As in a repository published before indices were kept by hash
+	dropByHash := func() {
+		for path := range memoryStorage.objects {
+			if strings.Contains(path, "/by-hash/") {
+				delete(memoryStorage.objects, path)
+			}
+		}
+	}
+	dropByHash()
+
+	delete(memoryStorage.objects, "dists/stable/main/binary-amd64/Packages")
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true}))
+	assert.Equal(t, string(amd64Packages), string(memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]))
+
+	delete(memoryStorage.objects, "dists/stable/main/binary-amd64/Packages")
+	memoryStorage.objects["dists/stable/main/binary-amd64/Packages.xz"] = []byte("not xz")
+	dropByHash()
+	assert.ErrorContains(t, app.Reindex(ctx, ReindexRequest{FromPool: true}), "--whole-pool")
+	assert.NotContains(t, memoryStorage.objects, "dists/stable/main/binary-amd64/Packages")
+}
+
+// Test a rebuild from the pool reports the pool files publish would have refused
+// rather than indexing them
+func TestReindexFromPoolInvalidFiles(t *testing.T) {
+	debs := map[string][]byte{
+		"tool_1.0_amd64.deb": buildDeb(t, debControl("tool", "1.0", "amd64", "A tool")),
+	}
+	config := &Config{Archive: "stable", Component: "main"}
+	app, memoryStorage, _ := newDebApplication(config, debs)
+	ctx := context.Background()
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	packages := memoryStorage.objects["dists/stable/main/binary-amd64/Packages"]
+
+	invalid := map[string][]byte{
+		// The control member is readable but the data member is cut off
+		"pool/main/t/tool/truncated_1.0_amd64.deb": debs["tool_1.0_amd64.deb"][:len(debs["tool_1.0_amd64.deb"])-20],
+		"pool/main/b/bad/Bad_1.0_amd64.deb":        buildDeb(t, debControl("Bad", "1.0", "amd64", "A bad name")),
+		"pool/main/b/bad/bad_x_amd64.deb":          buildDeb(t, debControl("bad", "not a version", "amd64", "A bad version")),
+	}
+	for path, data := range invalid {
+		memoryStorage.objects[path] = data
+		err := app.Reindex(ctx, ReindexRequest{FromPool: true, WholePool: true})
+		assert.ErrorContains(t, err, path)
+		assert.ErrorContains(t, err, "invalid .deb file")
+		assert.Equal(t, packages, memoryStorage.objects["dists/stable/main/binary-amd64/Packages"])
+		delete(memoryStorage.objects, path)
+	}
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, WholePool: true}))
+}
+
+// Test the components of a bucket share the pool cache, and a file overwritten with
+// the same size and modification time is read again
+func TestReindexFromPoolSharedCache(t *testing.T) {
+	debs := map[string][]byte{
+		"tool_1.0_amd64.deb":  buildDeb(t, debControl("tool", "1.0", "amd64", "A tool")),
+		"extra_1.0_amd64.deb": buildDeb(t, debControl("extra", "1.0", "amd64", "A contributed tool")),
+	}
+	config := &Config{Archive: "stable", Component: "main"}
+	app, memoryStorage, extractor := newDebApplication(config, debs)
+	ctx := context.Background()
+	cachePath := filepath.Join(t.TempDir(), "pool.json")
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	config.Component = "contrib"
+	assert.NoError(t, app.Publish(ctx, []string{"extra_1.0_amd64.deb"}))
+
+	extractor.reads = 0
+	config.Component = "main"
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, CachePath: cachePath}))
+	config.Component = "contrib"
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, CachePath: cachePath}))
+	assert.Equal(t, 2, extractor.reads)
+
+	// Rebuilding contrib kept the entries of main
+	config.Component = "main"
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, CachePath: cachePath}))
+	assert.Equal(t, 2, extractor.reads)
+
+	toolPath := "pool/main/t/tool/tool_1.0_amd64.deb"
+	rebuilt := buildDeb(t, debControl("tool", "1.0", "amd64", "B tool"))
+	assert.Equal(t, len(memoryStorage.objects[toolPath]), len(rebuilt))
+	modified := memoryStorage.modified[toolPath]
+	assert.NoError(t, memoryStorage.UploadBuffer(ctx, toolPath, bytes.NewBuffer(rebuilt)))
+	memoryStorage.modified[toolPath] = modified
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true, CachePath: cachePath}))
+	assert.Equal(t, 3, extractor.reads)
+	shown, err := app.Show(ctx, PackageFilter{PackageName: "tool"})
+	assert.NoError(t, err)
+	assert.Equal(t, "B tool", shown[0].Record.Paragraph.Get("Description"))
+
+	// A file that left the pool leaves the cache; the other component keeps its entry
+	delete(memoryStorage.objects, toolPath)
+	assert.ErrorIs(t, app.Reindex(ctx, ReindexRequest{FromPool: true, WholePool: true, CachePath: cachePath}), errNoPoolFiles)
+	data, err := os.ReadFile(cachePath)
+	assert.NoError(t, err)
+	var entries map[string]poolCacheEntry
+	assert.NoError(t, json.Unmarshal(data, &entries))
+	assert.NotContains(t, entries, toolPath)
+	assert.Contains(t, entries, "pool/contrib/e/extra/extra_1.0_amd64.deb")
+}
+
+// Test gc, verify and a rebuild from the pool treat a Filename written as ./pool/...
+// as the pool file it names
+func TestPoolFilenameNormalized(t *testing.T) {
+	debs := map[string][]byte{
+		"tool_1.0_amd64.deb": buildDeb(t, debControl("tool", "1.0", "amd64", "A tool")),
+	}
+	config := &Config{Archive: "stable", Component: "main", ByHashGenerations: 2}
+	app, memoryStorage, _ := newDebApplication(config, debs)
+	ctx := context.Background()
+	packagesPath := "dists/stable/main/binary-amd64/Packages"
+	poolPath := "pool/main/t/tool/tool_1.0_amd64.deb"
+
+	assert.NoError(t, app.Publish(ctx, []string{"tool_1.0_amd64.deb"}))
+	packages := strings.Replace(string(memoryStorage.objects[packagesPath]), "Filename: "+poolPath, "Filename: ./"+poolPath, 1)
+	assert.Contains(t, packages, "Filename: ./"+poolPath)
+	memoryStorage.objects[packagesPath] = []byte(packages)
+	// Two reindexes leave only generations that refer to ./pool/...
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{}))
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{}))
+	memoryStorage.modified[poolPath] = time.Now().Add(-48 * time.Hour)
+
+	problems, err := app.Verify(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	collected, err := app.GC(ctx, GCRequest{GracePeriod: 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Empty(t, collected)
+	assert.Contains(t, memoryStorage.objects, poolPath)
+
+	assert.NoError(t, app.Reindex(ctx, ReindexRequest{FromPool: true}))
+	assert.Contains(t, string(memoryStorage.objects[packagesPath]), "Filename: "+poolPath+"\n")
+}
@@ -0,0 +1,179 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/storage"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultByHashGenerations is the number of generations of an index kept below
+	// by-hash/ when Config.ByHashGenerations is not set.
+	defaultByHashGenerations = 3
+	// MinByHashGenerations keeps the generation the current suite Release file refers
+	// to until the new one replaces it. Smaller values are raised to it.
+	MinByHashGenerations = 2
+	// byHashGenerationsFile records the generations of an index directory below by-hash/.
+	byHashGenerationsFile = "generations.json"
+)
+
+// byHashTypes are the checksums under which index files are stored below by-hash/.
+// apt fetches an index by the strongest checksum the suite Release file lists for it.
+var byHashTypes = []struct {
+	name   string
+	digest func(deb.Checksums) string
+}{
+	{name: "SHA256", digest: func(c deb.Checksums) string { return c.SHA256 }},
+	{name: "SHA512", digest: func(c deb.Checksums) string { return c.SHA512 }},
+}
+
+// byHashGeneration is one write of an index directory: the by-hash/ paths, relative
+// to the directory, of the files written together.
+type byHashGeneration struct {
+	Date  time.Time `json:"date"`
+	Files []string  `json:"files"`
+	// Packages is the by-hash/ path of the uncompressed Packages file among Files, whose
+	// pool files stay referenced while the generation is kept.
+	Packages string `json:"packages,omitempty"`
+}
+
+// uploadByHash writes data to dir/by-hash/<checksum>/<digest> for every checksum in
+// byHashTypes.
+func (a *applicationImpl) uploadByHash(ctx context.Context, dir string, data *bytes.Buffer) error {
+	for _, path := range byHashPaths(deb.ComputeChecksums(data.Bytes())) {
+		if err := a.storage.UploadBuffer(ctx, filepath.Join(dir, path), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byHashPaths returns the by-hash/ paths, relative to the index directory, of a file
+// with the given checksums.
+func byHashPaths(checksums deb.Checksums) []string {
+	paths := make([]string, 0, len(byHashTypes))
+	for _, hashType := range byHashTypes {
+		paths = append(paths, filepath.Join("by-hash", hashType.name, hashType.digest(checksums)))
+	}
+	return paths
+}
+
+// byHashObjects returns the set of by-hash/ objects below dir.
+func (a *applicationImpl) byHashObjects(ctx context.Context, dir string) (map[string]bool, error) {
+	objects, err := a.storage.List(ctx, dir+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list by-hash objects: %w", err)
+	}
+	byHash := make(map[string]bool)
+	for _, object := range objects {
+		if strings.Contains(object.Key, "/by-hash/") {
+			byHash[object.Key] = true
+		}
+	}
+	return byHash, nil
+}
+
+// recordByHashGeneration records the files of a committed index as the newest
+// generation of its directory and prunes the by-hash/ objects of generations beyond
+// Config.ByHashGenerations. The index is already live, so failures are only logged.
+func (a *applicationImpl) recordByHashGeneration(ctx context.Context, staged *stagedIndex) {
+	dir := filepath.Dir(staged.packagesPath)
+
+	generation := byHashGeneration{Date: time.Now().UTC()}
+	for _, file := range staged.files {
+		paths := byHashPaths(deb.ComputeChecksums(file.data.Bytes()))
+		if file.path == staged.packagesPath {
+			generation.Packages = paths[0]
+		}
+		generation.Files = append(generation.Files, paths...)
+	}
+
+	generations, err := a.readByHashGenerations(ctx, dir)
+	if err != nil {
+		a.logger.WithError(err).Warnf("Failed to read by-hash generations of %s; not pruning", dir)
+		return
+	}
+	generations = append(generations, generation)
+	if keep := a.byHashGenerations(); len(generations) > keep {
+		generations = generations[len(generations)-keep:]
+	}
+
+	data, err := json.MarshalIndent(generations, "", "  ")
+	if err == nil {
+		err = a.storage.UploadBuffer(ctx, filepath.Join(dir, "by-hash", byHashGenerationsFile), bytes.NewBuffer(data))
+	}
+	if err != nil {
+		a.logger.WithError(err).Warnf("Failed to record by-hash generation of %s; not pruning", dir)
+		return
+	}
+
+	if err := a.pruneByHash(ctx, dir, generations); err != nil {
+		a.logger.WithError(err).Warnf("Failed to prune by-hash objects of %s", dir)
+	}
+}
+
+// pruneByHash deletes the by-hash/ objects of dir that no kept generation refers to.
+// Objects newer than the oldest kept generation are left alone, as they may belong
+// to an update that is not committed yet.
+func (a *applicationImpl) pruneByHash(ctx context.Context, dir string, generations []byHashGeneration) error {
+	kept := make(map[string]bool)
+	for _, generation := range generations {
+		for _, file := range generation.Files {
+			kept[filepath.Join(dir, file)] = true
+		}
+	}
+	cutoff := generations[0].Date
+
+	objects, err := a.storage.List(ctx, filepath.Join(dir, "by-hash")+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list by-hash objects: %w", err)
+	}
+
+	pruned := 0
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, "/"+byHashGenerationsFile) || kept[object.Key] || !object.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := a.storage.Delete(ctx, object.Key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", object.Key, err)
+		}
+		pruned++
+	}
+
+	if pruned > 0 {
+		a.logger.Infof("Pruned %d by-hash objects of %s", pruned, dir)
+	}
+	return nil
+}
+
+// readByHashGenerations returns the recorded generations of dir, oldest first.
+func (a *applicationImpl) readByHashGenerations(ctx context.Context, dir string) ([]byHashGeneration, error) {
+	var buffer bytes.Buffer
+	err := a.storage.DownloadFile(ctx, filepath.Join(dir, "by-hash", byHashGenerationsFile), &buffer)
+	if storage.IsNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []byHashGeneration
+	if err := json.Unmarshal(buffer.Bytes(), &generations); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", byHashGenerationsFile, err)
+	}
+	return generations, nil
+}
+
+// byHashGenerations returns the number of generations of an index kept below by-hash/.
+func (a *applicationImpl) byHashGenerations() int {
+	if a.config.ByHashGenerations <= 0 {
+		return defaultByHashGenerations
+	}
+	return max(a.config.ByHashGenerations, MinByHashGenerations)
+}
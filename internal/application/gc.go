@@ -0,0 +1,73 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/storage"
+	"time"
+)
+
+// GCRequest configures the garbage collection of pool files.
+type GCRequest struct {
+	// GracePeriod keeps unreferenced pool files uploaded more recently than this. A
+	// publish uploads its pool files before the indices that refer to them, so they
+	// must not be collected in between.
+	GracePeriod time.Duration
+	// DryRun only reports the files that would be deleted.
+	DryRun bool
+}
+
+// GC deletes the objects below pool/ that no Packages index of any suite refers to,
+// including the Packages files of the by-hash/ generations kept for each index, and
+// that are older than the grace period. It holds the lock of the configured suite
+// while it deletes; a dry run deletes nothing, so it neither takes nor waits for the
+// lock. It returns the collected objects, or the ones it would collect on a dry run.
+func (a *applicationImpl) GC(ctx context.Context, request GCRequest) ([]storage.ObjectInfo, error) {
+	if request.DryRun {
+		return a.gc(ctx, request)
+	}
+
+	var collected []storage.ObjectInfo
+	err := a.withLock(ctx, func(ctx context.Context) error {
+		var err error
+		collected, err = a.gc(ctx, request)
+		return err
+	})
+	return collected, err
+}
+
+func (a *applicationImpl) gc(ctx context.Context, request GCRequest) ([]storage.ObjectInfo, error) {
+	// List the pool before the indices, so a file published in between is either
+	// referenced or within the grace period
+	objects, err := a.storage.List(ctx, "pool/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pool files: %w", err)
+	}
+	referenced, err := a.referencedPoolFiles(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-request.GracePeriod)
+	var collected []storage.ObjectInfo
+	for _, object := range objects {
+		if referenced[object.Key] {
+			continue
+		}
+		if object.LastModified.After(cutoff) {
+			a.logger.Infof("Keeping %s; it is unreferenced but within the grace period", object.Key)
+			continue
+		}
+
+		if !request.DryRun {
+			if err := a.storage.Delete(ctx, object.Key); err != nil {
+				return collected, fmt.Errorf("failed to delete %s: %w", object.Key, err)
+			}
+			a.logger.Infof("Deleted %s", object.Key)
+		}
+		collected = append(collected, object)
+	}
+
+	a.logger.Infof("Collected %d of %d pool files", len(collected), len(objects))
+	return collected, nil
+}
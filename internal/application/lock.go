@@ -0,0 +1,341 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned when the suite is locked by another publisher.
+var ErrLocked = errors.New("repository is locked")
+
+// ErrCorruptLock is returned when the lock object of the suite cannot be parsed.
+var ErrCorruptLock = errors.New("repository lock is corrupt")
+
+// errLockLost cancels an operation whose lock was broken or taken over while it ran.
+var errLockLost = errors.New("repository lock was lost")
+
+const (
+	// defaultLockTTL is the lease of a lock when Config.LockTTL is not set.
+	defaultLockTTL = 2 * time.Minute
+	// lockPollInterval is how often a locked suite is checked while waiting for it.
+	lockPollInterval = 2 * time.Second
+	// MinLockTTL is the shortest lease of the suite lock. Shorter leases are raised to
+	// it, as the heartbeat could not renew them in time.
+	MinLockTTL = 10 * time.Second
+)
+
+// lockSettleDelay is how long a lock written without conditional writes is left
+// before it is read back. A process that read the suite as unlocked just before the
+// lock was written overwrites it within this delay, and the read-back sees it.
+var lockSettleDelay = time.Second
+
+// Lock is the advisory lock object stored at dists/<suite>/.lock. It is held while
+// publish, remove, prune and reindex change the indices of the suite and while gc
+// deletes pool files, and renewed by a heartbeat. A lock whose lease expired is stale
+// and may be taken over.
+type Lock struct {
+	// ID identifies the holder; two processes of the same owner get different IDs.
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	Acquired  time.Time `json:"acquired"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Expires   time.Time `json:"expires"`
+	// Corrupt marks the lock returned by BreakLock for a lock object that could not
+	// be parsed; its other fields are empty.
+	Corrupt bool `json:"-"`
+}
+
+// Stale reports whether the lease of the lock has expired at now.
+func (l *Lock) Stale(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+func (l *Lock) String() string {
+	if l.Corrupt {
+		return "a corrupt lock"
+	}
+	return fmt.Sprintf("%s on %s (pid %d), expires %s", l.Owner, l.Hostname, l.PID, l.Expires.Format(time.RFC3339))
+}
+
+// LockStatus returns the lock of the suite, or nil when it is not locked.
+func (a *applicationImpl) LockStatus(ctx context.Context) (*Lock, error) {
+	lock, _, err := a.readLock(ctx)
+	return lock, err
+}
+
+// BreakLock deletes the lock of the suite and returns it, or nil when it was not
+// locked. A lock whose lease has not expired, or that cannot be parsed, is only
+// broken with force.
+func (a *applicationImpl) BreakLock(ctx context.Context, force bool) (*Lock, error) {
+	lock, _, err := a.readLock(ctx)
+	if errors.Is(err, ErrCorruptLock) && force {
+		a.logger.WithError(err).Warn("Deleting a lock that cannot be parsed")
+		lock, err = &Lock{Corrupt: true}, nil
+	}
+	if err != nil || lock == nil {
+		return nil, err
+	}
+	if !force && !lock.Stale(time.Now()) {
+		return nil, fmt.Errorf("%w by %s", ErrLocked, lock)
+	}
+
+	if err := a.storage.Delete(ctx, a.lockPath()); err != nil {
+		return nil, fmt.Errorf("failed to delete lock: %w", err)
+	}
+	if !lock.Corrupt {
+		a.logger.Warnf("Broke the lock held by %s", lock)
+	}
+	return lock, nil
+}
+
+// withLock runs operation while holding the lock of the suite. The lock is renewed
+// in the background, and the context passed to operation is cancelled if the lock
+// is taken over by another process.
+func (a *applicationImpl) withLock(ctx context.Context, operation func(ctx context.Context) error) error {
+	lock, err := a.acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.renewLock(lockCtx, lock, cancel)
+	}()
+
+	err = operation(lockCtx)
+	cancel(nil)
+	<-done
+
+	// Release the lock even when ctx was cancelled
+	if releaseErr := a.releaseLock(context.WithoutCancel(ctx), lock); releaseErr != nil && err == nil {
+		err = releaseErr
+	}
+	if err != nil && errors.Is(context.Cause(lockCtx), errLockLost) {
+		return fmt.Errorf("%w: %w", errLockLost, err)
+	}
+	return err
+}
+
+// acquireLock takes the lock of the suite, waiting up to Config.LockWait for another
+// holder to release it. A stale lock is taken over.
+func (a *applicationImpl) acquireLock(ctx context.Context) (*Lock, error) {
+	hostname, _ := os.Hostname()
+	owner := a.config.LockOwner
+	if owner == "" {
+		owner = "aptforge"
+	}
+	id, err := newLockID()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(a.config.LockWait)
+	for {
+		current, etag, err := a.readLock(ctx)
+		if errors.Is(err, ErrCorruptLock) {
+			return nil, fmt.Errorf("%w; run aptforge lock break --force to delete it", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		switch {
+		case current == nil:
+		case current.Stale(now):
+			a.logger.Warnf("Taking over stale lock held by %s", current)
+		default:
+			if now.After(deadline) {
+				return nil, fmt.Errorf("%w by %s", ErrLocked, current)
+			}
+			a.logger.Infof("Waiting for the lock held by %s", current)
+			if err := sleepContext(ctx, lockPollInterval); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		lock := &Lock{ID: id, Owner: owner, Hostname: hostname, PID: os.Getpid(), Acquired: now}
+		err = a.writeLock(ctx, lock, etag)
+		if storage.IsPreconditionFailedError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Storage without conditional writes ignores the ETag, so read the lock back to
+		// make sure no other process overwrote it in the meantime. The ID of the lock
+		// tells this process apart from another one that wrote it at the same time.
+		if a.config.NoConditionalWrites {
+			if err := sleepContext(ctx, lockSettleDelay); err != nil {
+				return nil, err
+			}
+		}
+		written, _, err := a.readLock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if written == nil || written.ID != lock.ID {
+			continue
+		}
+
+		a.logger.Infof("Acquired lock %s", a.lockPath())
+		return lock, nil
+	}
+}
+
+// renewLock extends the lease of lock every third of the lease until ctx is done.
+// It cancels ctx when the lock was taken over or broken.
+func (a *applicationImpl) renewLock(ctx context.Context, lock *Lock, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(a.lockTTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := a.heartbeat(ctx, lock)
+		if errors.Is(err, errLockLost) {
+			a.logger.Errorf("Lock %s was broken or taken over", a.lockPath())
+			cancel(errLockLost)
+			return
+		}
+		if err != nil {
+			a.logger.WithError(err).Warn("Failed to renew lock")
+		}
+	}
+}
+
+// heartbeat renews the lease of lock. It returns errLockLost when the stored lock is
+// no longer lock, before or, without conditional writes, after it was renewed, as
+// another process may have taken over a lock whose holder stalled past its lease.
+func (a *applicationImpl) heartbeat(ctx context.Context, lock *Lock) error {
+	current, etag, err := a.readLock(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != lock.ID {
+		return errLockLost
+	}
+	if err := a.writeLock(ctx, lock, etag); err != nil {
+		return err
+	}
+	if !a.config.NoConditionalWrites {
+		return nil
+	}
+
+	if err := sleepContext(ctx, lockSettleDelay); err != nil {
+		return err
+	}
+	written, _, err := a.readLock(ctx)
+	if err != nil {
+		return err
+	}
+	if written == nil || written.ID != lock.ID {
+		return errLockLost
+	}
+	return nil
+}
+
+// releaseLock deletes lock unless it is no longer held by this process.
+func (a *applicationImpl) releaseLock(ctx context.Context, lock *Lock) error {
+	current, _, err := a.readLock(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != lock.ID {
+		a.logger.Warnf("Lock %s is no longer held; not releasing it", a.lockPath())
+		return nil
+	}
+
+	if err := a.storage.Delete(ctx, a.lockPath()); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	a.logger.Infof("Released lock %s", a.lockPath())
+	return nil
+}
+
+// readLock downloads the lock of the suite with its ETag. It returns a nil lock when
+// the suite is not locked.
+func (a *applicationImpl) readLock(ctx context.Context) (*Lock, string, error) {
+	var lockBuffer bytes.Buffer
+	etag, err := a.storage.DownloadFileWithETag(ctx, a.lockPath(), &lockBuffer)
+	if storage.IsNotFoundError(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download lock: %w", err)
+	}
+
+	var lock Lock
+	if err := json.Unmarshal(lockBuffer.Bytes(), &lock); err != nil {
+		return nil, "", fmt.Errorf("%w: failed to parse %s: %w", ErrCorruptLock, a.lockPath(), err)
+	}
+	return &lock, etag, nil
+}
+
+// writeLock renews the heartbeat and lease of lock and writes it, provided the
+// stored lock still has the given ETag or the storage has no conditional writes.
+func (a *applicationImpl) writeLock(ctx context.Context, lock *Lock, etag string) error {
+	lock.Heartbeat = time.Now()
+	lock.Expires = lock.Heartbeat.Add(a.lockTTL())
+
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lock: %w", err)
+	}
+	if err := a.uploadIfMatch(ctx, a.lockPath(), bytes.NewBuffer(data), etag); err != nil {
+		return fmt.Errorf("failed to write lock: %w", err)
+	}
+	return nil
+}
+
+// lockPath returns the path of the lock object of the suite.
+func (a *applicationImpl) lockPath() string {
+	return filepath.Join(a.suiteDir(), ".lock")
+}
+
+func (a *applicationImpl) lockTTL() time.Duration {
+	if a.config.LockTTL <= 0 {
+		return defaultLockTTL
+	}
+	return max(a.config.LockTTL, MinLockTTL)
+}
+
+// newLockID returns a random identifier for a lock holder.
+func newLockID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
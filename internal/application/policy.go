@@ -0,0 +1,214 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/storage"
+	"slices"
+	"strings"
+)
+
+// ErrNotAllowed is returned when a value follows the naming rules but is excluded by the repository policy.
+var ErrNotAllowed = errors.New("not allowed by repository policy")
+
+// policyPath is the object holding the policy stored in the repository. It sits
+// directly below dists/, where no index or suite file is kept.
+const policyPath = "dists/policy.json"
+
+// Policy restricts the suites, components and architectures accepted. Values must
+// follow the Debian naming rules in any case; an empty list allows every value that
+// does.
+type Policy struct {
+	Suites        []string `json:"suites,omitempty"`
+	Components    []string `json:"components,omitempty"`
+	Architectures []string `json:"architectures,omitempty"`
+}
+
+// RepositoryPolicy is the policy stored in the repository at dists/policy.json.
+// Publish, Remove, Prune and Reindex enforce it on every run, on top of the policy of
+// the run in Config.Policy.
+type RepositoryPolicy struct {
+	Policy
+	// Retention is the retention policy of each component, by <suite>/<component>.
+	// Publish and Prune apply it unless the run has a retention policy of its own.
+	Retention map[string]Retention `json:"retention,omitempty"`
+}
+
+// ComponentRetention returns the stored retention policy of component of suite.
+func (p RepositoryPolicy) ComponentRetention(suite, component string) Retention {
+	return p.Retention[suite+"/"+component]
+}
+
+// SetComponentRetention stores retention as the retention policy of component of
+// suite. A retention policy that drops nothing is removed.
+func (p *RepositoryPolicy) SetComponentRetention(suite, component string, retention Retention) {
+	key := suite + "/" + component
+	if !retention.Enabled() {
+		delete(p.Retention, key)
+		return
+	}
+	if p.Retention == nil {
+		p.Retention = make(map[string]Retention)
+	}
+	p.Retention[key] = retention
+}
+
+// CheckSuite checks a suite or codename name.
+func (p Policy) CheckSuite(name string) error {
+	if err := deb.ValidateSuiteName(name); err != nil {
+		return err
+	}
+	return checkAllowed("suite", name, p.Suites)
+}
+
+// CheckComponent checks a component name.
+func (p Policy) CheckComponent(name string) error {
+	if err := deb.ValidateComponentName(name); err != nil {
+		return err
+	}
+	return checkAllowed("component", name, p.Components)
+}
+
+// CheckArchitecture checks an architecture against the dpkg architecture table.
+func (p Policy) CheckArchitecture(name string) error {
+	if err := deb.ValidateArchitecture(name); err != nil {
+		return err
+	}
+	return checkAllowed("architecture", name, p.Architectures)
+}
+
+// Validate checks that every allowed value follows the Debian naming rules.
+func (p Policy) Validate() error {
+	for _, suite := range p.Suites {
+		if err := deb.ValidateSuiteName(suite); err != nil {
+			return err
+		}
+	}
+	for _, component := range p.Components {
+		if err := deb.ValidateComponentName(component); err != nil {
+			return err
+		}
+	}
+	for _, architecture := range p.Architectures {
+		if err := deb.ValidateArchitecture(architecture); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAllowed(kind, name string, allowed []string) error {
+	if len(allowed) > 0 && !slices.Contains(allowed, name) {
+		return fmt.Errorf("%s %q is %w; allowed values are: %s", kind, name, ErrNotAllowed, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// StoredPolicy returns the policy stored in the repository, which is empty when none
+// was stored.
+func (a *applicationImpl) StoredPolicy(ctx context.Context) (RepositoryPolicy, error) {
+	policy, _, err := a.readPolicy(ctx)
+	return policy, err
+}
+
+// UpdatePolicy applies update to the policy stored in the repository and stores the
+// result. When another process stores the policy in the meantime, update is applied
+// again to its policy.
+func (a *applicationImpl) UpdatePolicy(ctx context.Context, update func(policy *RepositoryPolicy)) (RepositoryPolicy, error) {
+	for attempt := 1; ; attempt++ {
+		policy, etag, err := a.readPolicy(ctx)
+		if err != nil {
+			return RepositoryPolicy{}, err
+		}
+		update(&policy)
+		if err := policy.Validate(); err != nil {
+			return RepositoryPolicy{}, fmt.Errorf("invalid policy: %w", err)
+		}
+		for key, retention := range policy.Retention {
+			if retention.KeepVersions < 0 || retention.MaxAge < 0 {
+				return RepositoryPolicy{}, fmt.Errorf("invalid retention policy of %s: values must not be negative", key)
+			}
+		}
+
+		data, err := json.MarshalIndent(policy, "", "  ")
+		if err != nil {
+			return RepositoryPolicy{}, fmt.Errorf("failed to encode policy: %w", err)
+		}
+		err = a.uploadIfMatch(ctx, policyPath, bytes.NewBuffer(data), etag)
+		if storage.IsPreconditionFailedError(err) && attempt < maxPackagesUpdateAttempts {
+			a.logger.Warnf("%s was modified concurrently; retrying (attempt %d of %d)", policyPath, attempt+1, maxPackagesUpdateAttempts)
+			continue
+		}
+		if err != nil {
+			return RepositoryPolicy{}, fmt.Errorf("failed to upload %s: %w", policyPath, err)
+		}
+		a.logger.Infof("Stored the repository policy in %s", policyPath)
+		return policy, nil
+	}
+}
+
+// ClearPolicy deletes the policy stored in the repository.
+func (a *applicationImpl) ClearPolicy(ctx context.Context) error {
+	if err := a.storage.Delete(ctx, policyPath); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", policyPath, err)
+	}
+	a.logger.Infof("Deleted the repository policy %s", policyPath)
+	return nil
+}
+
+// readPolicy downloads the policy stored in the repository with its ETag. A missing
+// policy is empty and has no ETag. A policy that cannot be parsed is an error rather
+// than no restriction at all.
+func (a *applicationImpl) readPolicy(ctx context.Context) (RepositoryPolicy, string, error) {
+	var policyBuffer bytes.Buffer
+	etag, err := a.storage.DownloadFileWithETag(ctx, policyPath, &policyBuffer)
+	if storage.IsNotFoundError(err) {
+		return RepositoryPolicy{}, "", nil
+	}
+	if err != nil {
+		return RepositoryPolicy{}, "", fmt.Errorf("failed to download %s: %w", policyPath, err)
+	}
+
+	var policy RepositoryPolicy
+	if err := json.Unmarshal(policyBuffer.Bytes(), &policy); err != nil {
+		return RepositoryPolicy{}, "", fmt.Errorf("failed to parse %s: %w", policyPath, err)
+	}
+	return policy, etag, nil
+}
+
+// enforcePolicy checks the configured suite, component and architectures against
+// the policy stored in the repository, and returns it so that the architectures an
+// operation writes can be checked as well.
+func (a *applicationImpl) enforcePolicy(ctx context.Context) (RepositoryPolicy, error) {
+	policy, _, err := a.readPolicy(ctx)
+	if err != nil {
+		return RepositoryPolicy{}, err
+	}
+
+	if err := policy.CheckSuite(a.config.Archive); err != nil {
+		return RepositoryPolicy{}, fmt.Errorf("invalid archive: %w", err)
+	}
+	if err := policy.CheckComponent(a.config.Component); err != nil {
+		return RepositoryPolicy{}, fmt.Errorf("invalid component: %w", err)
+	}
+	if a.config.Architecture != "" {
+		if err := policy.CheckArchitecture(a.config.Architecture); err != nil {
+			return RepositoryPolicy{}, fmt.Errorf("invalid architecture: %w", err)
+		}
+	}
+	for _, component := range a.config.Components {
+		if err := policy.CheckComponent(component); err != nil {
+			return RepositoryPolicy{}, fmt.Errorf("invalid suite component: %w", err)
+		}
+	}
+	for _, architecture := range a.config.Architectures {
+		if err := policy.CheckArchitecture(architecture); err != nil {
+			return RepositoryPolicy{}, fmt.Errorf("invalid suite architecture: %w", err)
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,125 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/storage"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// poolCache remembers the stanza built for every pool file by a rebuild from the
+// pool, so the next rebuild only downloads the files that changed since. It is kept
+// as JSON in a local file shared by the rebuilds of every suite and component of a
+// bucket. A nil cache remembers nothing.
+type poolCache struct {
+	path    string
+	entries map[string]poolCacheEntry
+	mu      sync.Mutex
+}
+
+// poolCacheEntry is the stanza of a pool file, valid while the file keeps its size,
+// modification time and ETag.
+type poolCacheEntry struct {
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	ETag         string    `json:"etag"`
+	Stanza       string    `json:"stanza"`
+}
+
+// loadPoolCache reads the cache at path. A missing or unreadable cache starts empty,
+// and an empty path disables the cache.
+func loadPoolCache(logger *log.Entry, path string) *poolCache {
+	if path == "" {
+		return nil
+	}
+
+	cache := &poolCache{path: path, entries: make(map[string]poolCacheEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &cache.entries)
+	}
+	if err != nil {
+		logger.WithError(err).Warnf("Ignoring unreadable pool cache %s", path)
+		cache.entries = make(map[string]poolCacheEntry)
+	}
+	return cache
+}
+
+// lookup returns the cached stanza of object, if object is unchanged since it was cached.
+func (c *poolCache) lookup(object storage.ObjectInfo) (deb.PackageRecord, bool) {
+	if c == nil {
+		return deb.PackageRecord{}, false
+	}
+
+	c.mu.Lock()
+	entry, found := c.entries[object.Key]
+	c.mu.Unlock()
+	if !found || entry.Size != object.Size || !entry.LastModified.Equal(object.LastModified) || entry.ETag != object.ETag {
+		return deb.PackageRecord{}, false
+	}
+
+	records, err := deb.ParsePackagesFile(entry.Stanza)
+	if err != nil || len(records) != 1 {
+		return deb.PackageRecord{}, false
+	}
+	return records[0], true
+}
+
+// store remembers the stanza of object.
+func (c *poolCache) store(object storage.ObjectInfo, record deb.PackageRecord) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[object.Key] = poolCacheEntry{Size: object.Size, LastModified: object.LastModified, ETag: object.ETag, Stanza: record.Paragraph.String()}
+}
+
+// save writes the cache, dropping the entries below prefix that are not among listed,
+// the objects found below prefix, as those files have left the pool. Entries of other
+// prefixes are kept for the rebuilds of other components.
+func (c *poolCache) save(prefix string, listed []storage.ObjectInfo) error {
+	if c == nil {
+		return nil
+	}
+
+	present := make(map[string]bool, len(listed))
+	for _, object := range listed {
+		present[object.Key] = true
+	}
+	c.mu.Lock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) && !present[key] {
+			delete(c.entries, key)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode pool cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create pool cache directory: %w", err)
+	}
+	// Write to a temporary file first so an interrupted save keeps the old cache
+	temp := c.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write pool cache: %w", err)
+	}
+	if err := os.Rename(temp, c.path); err != nil {
+		return fmt.Errorf("failed to write pool cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/filereader"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// publishItem is a .deb being published by Publish.
+type publishItem struct {
+	path          string
+	file          filereader.File
+	metadata      *deb.PackageMetadata
+	architectures []string
+}
+
+// Publish uploads the .deb files at filePaths to the pool and adds them to the
+// Packages index of their target architectures. Every file is validated and checked
+// against the conflict policy before anything is uploaded. The pool uploads run
+// concurrently, and each affected index and the Release files are written once at the end
+// while holding the suite lock. The retention policy of the run, or else the one stored
+// for the component, is applied to the indices written.
+func (a *applicationImpl) Publish(ctx context.Context, filePaths []string) error {
+	if len(filePaths) == 0 {
+		return fmt.Errorf("no .deb files to publish")
+	}
+
+	items, err := a.preparePublishItems(ctx, filePaths)
+	defer func() {
+		for _, item := range items {
+			a.CloseFile(item.file)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	return a.withLock(ctx, func(ctx context.Context) error {
+		return a.publishItems(ctx, items)
+	})
+}
+
+// publishItems uploads the prepared items and adds them to their indices.
+func (a *applicationImpl) publishItems(ctx context.Context, items []*publishItem) error {
+	if err := a.checkSigning(ctx); err != nil {
+		return err
+	}
+	policy, err := a.enforcePolicy(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		for _, architecture := range item.architectures {
+			if err := policy.CheckArchitecture(architecture); err != nil {
+				return fmt.Errorf("%s: invalid package architecture: %w", item.path, err)
+			}
+		}
+	}
+
+	// Read every affected index once
+	indices := make(map[string][]deb.PackageRecord)
+	var architectures []string
+	for _, item := range items {
+		for _, architecture := range item.architectures {
+			if _, read := indices[architecture]; read {
+				continue
+			}
+			records, err := a.readPackagesIndex(ctx, packagesIndex{Component: a.config.Component, Architecture: architecture})
+			if err != nil {
+				return err
+			}
+			indices[architecture] = records
+			architectures = append(architectures, architecture)
+		}
+	}
+
+	// Apply the conflict policy before anything is uploaded
+	var publish []*publishItem
+	for _, item := range items {
+		accepted, err := a.acceptPublishItem(indices, item)
+		if err != nil {
+			return err
+		}
+		if accepted {
+			publish = append(publish, item)
+		}
+	}
+	if len(publish) == 0 {
+		a.logger.Infof("Every package is already published; nothing to do")
+		return nil
+	}
+
+	if err := a.uploadPoolFiles(ctx, publish); err != nil {
+		return err
+	}
+
+	a.logger.Infof("Updating repository metadata...")
+
+	// The retention policy is applied to the indices as they are written. The packages
+	// being published are kept even when the policy would prune them, such as a version
+	// older than the newest KeepVersions, so none is reported published but left out.
+	var retain func(records []deb.PackageRecord, keep map[deb.PackageKey]bool) ([]deb.PackageRecord, []deb.PackageRecord)
+	if retention := a.retention(policy); retention.Enabled() {
+		var err error
+		if retain, err = a.retentionFilter(ctx, retention); err != nil {
+			return err
+		}
+	}
+
+	// Add the packages to their indices and write each affected index once. The
+	// stanzas are merged into the index as it is when written, so packages published
+	// concurrently since it was read are kept.
+	var updates []indexUpdate
+	prunedByIndex := make(map[packagesIndex][]deb.PackageRecord)
+	for _, architecture := range architectures {
+		var records []deb.PackageRecord
+		published := make(map[deb.PackageKey]bool)
+		for _, item := range publish {
+			if !slices.Contains(item.architectures, architecture) {
+				continue
+			}
+			record, err := deb.NewPackageRecord(deb.CreatePackagesParagraph(mapMetadataToPackageContents(item.metadata)))
+			if err != nil {
+				return fmt.Errorf("failed to create Packages stanza for %s: %w", item.path, err)
+			}
+			records = append(records, record)
+			published[record.Key] = true
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		index := packagesIndex{Component: a.config.Component, Architecture: architecture}
+		updates = append(updates, indexUpdate{
+			index: index,
+			update: func(existing []deb.PackageRecord) ([]deb.PackageRecord, bool, error) {
+				var err error
+				for _, record := range records {
+					if existing, err = a.mergePackageRecord(existing, record); err != nil {
+						return nil, false, err
+					}
+				}
+				if retain != nil {
+					existing, prunedByIndex[index] = retain(existing, published)
+				}
+				return existing, true, nil
+			},
+		})
+	}
+
+	written, err := a.updatePackagesIndices(ctx, updates)
+	if err != nil {
+		return err
+	}
+	a.logPruned(written, prunedByIndex)
+	var updated []string
+	for _, index := range written {
+		updated = append(updated, index.Architecture)
+	}
+
+	// Upload suite-level Release file
+	err = a.UploadSuiteReleaseFile(ctx, a.suiteReleasePath(), updated, []string{a.config.Component})
+	if err != nil {
+		return fmt.Errorf("failed to upload suite-level Release file: %w", err)
+	}
+
+	for _, item := range publish {
+		a.logger.Infof("Published %s", item.metadata.Filename)
+	}
+	return nil
+}
+
+// ExpandDebPaths expands the --file arguments of a publish into .deb paths. Each
+// argument is a file, a glob pattern or a directory, whose .deb files are taken.
+// Paths are returned in argument order, each one once.
+func ExpandDebPaths(arguments []string) ([]string, error) {
+	var paths []string
+	for _, argument := range arguments {
+		info, err := os.Stat(argument)
+		switch {
+		case err == nil && info.IsDir():
+			matches, err := filepath.Glob(filepath.Join(argument, "*.deb"))
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no .deb files in directory %s", argument)
+			}
+			paths = appendMissing(paths, matches...)
+		case err == nil:
+			paths = appendMissing(paths, argument)
+		default:
+			matches, globErr := filepath.Glob(argument)
+			if globErr != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", argument, globErr)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", argument)
+			}
+			paths = appendMissing(paths, matches...)
+		}
+	}
+	return paths, nil
+}
+
+// preparePublishItems opens and validates every .deb and resolves its target
+// architectures. The returned items must be closed even when an error is returned.
+func (a *applicationImpl) preparePublishItems(ctx context.Context, filePaths []string) ([]*publishItem, error) {
+	var items []*publishItem
+	seen := make(map[deb.PackageKey]string)
+	for _, filePath := range filePaths {
+		file, err := a.LoadDebFile(filePath)
+		if err != nil {
+			return items, fmt.Errorf("failed to load %s: %w", filePath, err)
+		}
+		item := &publishItem{path: filePath, file: file}
+		items = append(items, item)
+
+		item.metadata, err = a.ExtractDebMetadata(file)
+		if err != nil {
+			return items, fmt.Errorf("%s: %w", filePath, err)
+		}
+
+		key := metadataKey(item.metadata)
+		if other, found := seen[key]; found {
+			return items, fmt.Errorf("%s and %s are both %s", other, filePath, key)
+		}
+		seen[key] = filePath
+
+		// Publish to the package's own architecture, or to every suite architecture for Architecture: all
+		item.architectures, err = a.TargetArchitectures(ctx, item.metadata)
+		if err != nil {
+			return items, fmt.Errorf("failed to determine target architecture of %s: %w", filePath, err)
+		}
+	}
+
+	return items, nil
+}
+
+// acceptPublishItem applies the conflict policy to item in each of its indices.
+// It returns false when the package is already published and must be left alone.
+func (a *applicationImpl) acceptPublishItem(indices map[string][]deb.PackageRecord, item *publishItem) (bool, error) {
+	for _, architecture := range item.architectures {
+		accepted, err := a.checkConflict(indices[architecture], item.metadata, item.file)
+		if err != nil {
+			return false, fmt.Errorf("failed to check for an existing package: %w", err)
+		}
+		if !accepted {
+			a.logger.Infof("%s is already published for %s; skipping it", item.path, architecture)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// uploadPoolFiles uploads the .deb of every item to the pool, running up to
+// Config.Concurrency uploads at a time.
+func (a *applicationImpl) uploadPoolFiles(ctx context.Context, items []*publishItem) error {
+	concurrency := max(a.config.Concurrency, 1)
+	semaphore := make(chan struct{}, concurrency)
+	errs := make([]error, len(items))
+
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if err := a.UploadDebFile(ctx, item.metadata, item.file); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", item.path, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/storage"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// errNoPoolFiles is returned by a rebuild from the pool that finds no .deb files, rather
+// than emptying the indices.
+var errNoPoolFiles = errors.New("no .deb files found in the pool")
+
+// rebuildFromPool regenerates the Packages indices of the configured component from
+// the .deb files below pool/<component>/, replacing their current stanzas, and then
+// the suite Release file. Unless request.WholePool is set, only the files the indices
+// refer to are read. Pool files whose stanza is in the cache at request.CachePath and
+// that have not changed since are not downloaded. Every architecture rebuilt must be
+// allowed by policy.
+func (a *applicationImpl) rebuildFromPool(ctx context.Context, request ReindexRequest, policy RepositoryPolicy) error {
+	poolDir := filepath.Join("pool", a.config.Component) + "/"
+	objects, err := a.storage.List(ctx, poolDir)
+	if err != nil {
+		return fmt.Errorf("failed to list pool files: %w", err)
+	}
+	poolObjects := objects
+	if !request.WholePool {
+		if objects, err = a.suitePoolObjects(ctx, objects); err != nil {
+			return err
+		}
+	}
+
+	cache := loadPoolCache(a.logger, request.CachePath)
+	records, err := a.poolRecords(ctx, objects, cache)
+	// The stanzas read so far stay valid even when the rebuild stops here
+	if err := cache.save(poolDir, poolObjects); err != nil {
+		a.logger.WithError(err).Warn("Failed to save pool cache")
+	}
+	if err != nil {
+		return err
+	}
+
+	architectures, err := a.rebuildArchitectures(ctx, records)
+	if err != nil {
+		return err
+	}
+	for _, architecture := range architectures {
+		if err := policy.CheckArchitecture(architecture); err != nil {
+			return fmt.Errorf("invalid pool architecture: %w", err)
+		}
+	}
+
+	updates := make([]indexUpdate, len(architectures))
+	for i, architecture := range architectures {
+		var indexRecords []deb.PackageRecord
+		for _, record := range records {
+			if record.Key.Architecture == architecture || record.Key.Architecture == deb.ArchitectureAll {
+				indexRecords = append(indexRecords, record)
+			}
+		}
+		updates[i] = indexUpdate{
+			index: packagesIndex{Component: a.config.Component, Architecture: architecture},
+			update: func([]deb.PackageRecord) ([]deb.PackageRecord, bool, error) {
+				return indexRecords, true, nil
+			},
+			rebuild: true,
+		}
+	}
+	if _, err := a.updatePackagesIndices(ctx, updates); err != nil {
+		return err
+	}
+
+	if err := a.UploadSuiteReleaseFile(ctx, a.suiteReleasePath(), nil, nil); err != nil {
+		return fmt.Errorf("failed to upload suite-level Release file: %w", err)
+	}
+
+	a.logger.Infof("Rebuilt %d Packages indices from %d pool files", len(architectures), len(records))
+	return nil
+}
+
+// suitePoolObjects returns the objects among the pool files objects that the Packages
+// indices of the configured component refer to.
+func (a *applicationImpl) suitePoolObjects(ctx context.Context, objects []storage.ObjectInfo) ([]storage.ObjectInfo, error) {
+	architectures, err := a.suiteArchitectures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, architecture := range architectures {
+		index := packagesIndex{Component: a.config.Component, Architecture: architecture}
+		records, err := a.readIndexRecords(ctx, index.path(a.config.Archive))
+		if err != nil {
+			return nil, fmt.Errorf("%w; rebuild with --whole-pool to read every pool file", err)
+		}
+		addPoolReferences(referenced, records)
+	}
+
+	var suiteObjects []storage.ObjectInfo
+	for _, object := range objects {
+		if referenced[object.Key] {
+			suiteObjects = append(suiteObjects, object)
+			delete(referenced, object.Key)
+		}
+	}
+	for missing := range referenced {
+		a.logger.Warnf("Dropping %s; the suite refers to it but it is not in the pool", missing)
+	}
+	return suiteObjects, nil
+}
+
+// suiteArchitectures returns the configured architectures, or those discovered from
+// the indices of the suite.
+func (a *applicationImpl) suiteArchitectures(ctx context.Context) ([]string, error) {
+	if len(a.config.Architectures) > 0 {
+		return slices.Clone(a.config.Architectures), nil
+	}
+	indices, err := a.listSuiteIndices(ctx, a.suiteDir())
+	if err != nil {
+		return nil, err
+	}
+	_, architectures := suiteLayout(indices)
+	return architectures, nil
+}
+
+// rebuildArchitectures returns the architectures whose index is rebuilt: the
+// configured or discovered suite architectures, plus those of the packages found in
+// the pool.
+func (a *applicationImpl) rebuildArchitectures(ctx context.Context, records []deb.PackageRecord) ([]string, error) {
+	architectures, err := a.suiteArchitectures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Key.Architecture != deb.ArchitectureAll {
+			architectures = appendMissing(architectures, record.Key.Architecture)
+		}
+	}
+	sort.Strings(architectures)
+
+	if len(architectures) == 0 && len(records) > 0 {
+		return nil, fmt.Errorf("the pool only has Architecture: %s packages and the suite has no architectures; pass the architectures explicitly", deb.ArchitectureAll)
+	}
+	return architectures, nil
+}
+
+// poolRecords returns the stanzas of the .deb files among objects, reading up to
+// Config.Concurrency files at a time. It fails if any of them cannot be read, as an
+// index rebuilt without it would drop the package, or if there are none. Of several
+// files with the same package key the first is kept.
+func (a *applicationImpl) poolRecords(ctx context.Context, objects []storage.ObjectInfo, cache *poolCache) ([]deb.PackageRecord, error) {
+	concurrency := max(a.config.Concurrency, 1)
+	semaphore := make(chan struct{}, concurrency)
+	records := make([]*deb.PackageRecord, len(objects))
+	errs := make([]error, len(objects))
+
+	var wg sync.WaitGroup
+	for i, object := range objects {
+		if filepath.Ext(object.Key) != ".deb" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if record, found := cache.lookup(object); found {
+				records[i] = &record
+				return
+			}
+			record, err := a.readPoolRecord(ctx, object.Key)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", object.Key, err)
+				return
+			}
+			cache.store(object, record)
+			records[i] = &record
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("failed to read pool files: %w", err)
+	}
+
+	var result []deb.PackageRecord
+	keys := make(map[deb.PackageKey]string)
+	for i, record := range records {
+		if record == nil {
+			continue
+		}
+		if first, found := keys[record.Key]; found {
+			a.logger.Warnf("Skipping %s; %s is already provided by %s", objects[i].Key, record.Key, first)
+			continue
+		}
+		keys[record.Key] = objects[i].Key
+		result = append(result, *record)
+	}
+	if len(result) == 0 {
+		return nil, errNoPoolFiles
+	}
+
+	return result, nil
+}
+
+// readPoolRecord builds the stanza of the pool file at key. The file is streamed: its
+// control member is extracted as it is read, and it is validated and hashed alongside.
+// The file must pass the checks publish applies to a new package.
+func (a *applicationImpl) readPoolRecord(ctx context.Context, key string) (deb.PackageRecord, error) {
+	object, err := a.storage.Download(ctx, key)
+	if err != nil {
+		return deb.PackageRecord{}, fmt.Errorf("failed to download: %w", err)
+	}
+	if closer, ok := object.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	validatorReader, validatorWriter := io.Pipe()
+	validation := make(chan error, 1)
+	go func() {
+		err := deb.ValidateReader(validatorReader)
+		// Keep reading so the extractor is never blocked on the validator
+		_, _ = io.Copy(io.Discard, validatorReader)
+		validation <- err
+	}()
+
+	hasher := deb.NewHasher()
+	reader := io.TeeReader(object, io.MultiWriter(hasher, validatorWriter))
+	metadata, err := a.extractor.ExtractPackageMetadata(reader)
+	if err == nil {
+		if _, copyErr := io.Copy(io.Discard, reader); copyErr != nil {
+			err = fmt.Errorf("failed to download: %w", copyErr)
+		}
+	}
+	validatorWriter.CloseWithError(err)
+	validationErr := <-validation
+	if err != nil {
+		return deb.PackageRecord{}, err
+	}
+	if validationErr != nil {
+		return deb.PackageRecord{}, fmt.Errorf("invalid .deb file: %w", validationErr)
+	}
+	if err := deb.ValidatePackageName(metadata.PackageName); err != nil {
+		return deb.PackageRecord{}, fmt.Errorf("invalid .deb file: %w", err)
+	}
+	if _, err := deb.ParseVersion(metadata.Version); err != nil {
+		return deb.PackageRecord{}, fmt.Errorf("invalid .deb file: %w", err)
+	}
+
+	metadata.Filename = key
+	metadata.Checksums = hasher.Sum()
+	a.logger.Debugf("Read %s from %s", metadataKey(metadata), key)
+	return deb.NewPackageRecord(deb.CreatePackagesParagraph(mapMetadataToPackageContents(metadata)))
+}
@@ -0,0 +1,524 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/storage"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrPackageNotFound is returned when no stanza matches the requested package.
+var ErrPackageNotFound = errors.New("package not found")
+
+// PackageEntry is a stanza of a Packages index together with the index it was read from.
+type PackageEntry struct {
+	Component    string
+	Architecture string
+	Record       deb.PackageRecord
+}
+
+// packagesIndex identifies the Packages index of one component and architecture of the suite.
+type packagesIndex struct {
+	Component    string
+	Architecture string
+}
+
+// path returns the path of the Packages file of the index.
+func (i packagesIndex) path(archive string) string {
+	return filepath.Join(deb.ConstructRepoPath(archive, i.Component, i.Architecture), "Packages")
+}
+
+// PackageFilter selects the stanzas returned by List and Show. Empty fields match everything.
+type PackageFilter struct {
+	Component    string
+	Architecture string
+	PackageName  string
+	Version      string
+}
+
+// matches reports whether entry is selected by the filter.
+func (f PackageFilter) matches(entry PackageEntry) bool {
+	return (f.Component == "" || entry.Component == f.Component) &&
+		(f.Architecture == "" || entry.Architecture == f.Architecture) &&
+		(f.PackageName == "" || entry.Record.Key.PackageName == f.PackageName) &&
+		(f.Version == "" || entry.Record.Key.Version == f.Version)
+}
+
+// List returns the stanzas of the Packages indices of the suite selected by filter.
+func (a *applicationImpl) List(ctx context.Context, filter PackageFilter) ([]PackageEntry, error) {
+	indices, err := a.listPackagesIndices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PackageEntry
+	for _, index := range indices {
+		if (filter.Component != "" && index.Component != filter.Component) || (filter.Architecture != "" && index.Architecture != filter.Architecture) {
+			continue
+		}
+
+		records, err := a.readPackagesIndex(ctx, index)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			entry := PackageEntry{Component: index.Component, Architecture: index.Architecture, Record: record}
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// Show returns the stanzas of the package selected by filter, in every index it is
+// published in. It fails with ErrPackageNotFound when there is none.
+func (a *applicationImpl) Show(ctx context.Context, filter PackageFilter) ([]PackageEntry, error) {
+	if filter.PackageName == "" {
+		return nil, fmt.Errorf("no package name given")
+	}
+
+	entries, err := a.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		if filter.Version != "" {
+			return nil, fmt.Errorf("%s=%s: %w", filter.PackageName, filter.Version, ErrPackageNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", filter.PackageName, ErrPackageNotFound)
+	}
+
+	return entries, nil
+}
+
+// RemoveRequest selects the stanzas dropped by Remove.
+type RemoveRequest struct {
+	PackageName string
+	// Version restricts the removal to the versions that stand in Relation to it, in
+	// dpkg order. Empty matches every version.
+	Version string
+	// Relation compares the version of a stanza to Version. Empty means deb.RelationEqual.
+	Relation deb.Relation
+	// Architecture restricts the removal to the binary-<arch> index of one architecture.
+	// "all" matches Architecture: all stanzas in every index. Empty matches every index.
+	Architecture string
+	// DeletePool deletes the pool files of the removed stanzas once no current index of
+	// any suite refers to them anymore, even if a kept by-hash generation still does.
+	DeletePool bool
+}
+
+// matches reports whether record, read from the index of architecture, is selected by the request.
+func (r RemoveRequest) matches(architecture string, record deb.PackageRecord) bool {
+	if record.Key.PackageName != r.PackageName {
+		return false
+	}
+	if r.Version != "" && !r.relation().Satisfies(record.Key.Version, r.Version) {
+		return false
+	}
+	switch r.Architecture {
+	case "":
+		return true
+	case deb.ArchitectureAll:
+		return record.Key.Architecture == deb.ArchitectureAll
+	default:
+		return architecture == r.Architecture
+	}
+}
+
+// relation returns the relation of the request, defaulting to equality.
+func (r RemoveRequest) relation() deb.Relation {
+	if r.Relation == "" {
+		return deb.RelationEqual
+	}
+	return r.Relation
+}
+
+// Remove drops the stanzas selected by request from the Packages indices of the
+// configured component, regenerating the compressed indices and both Release levels of every
+// index it changes. It returns the keys of the removed stanzas, each once. The suite lock
+// is held while the indices are changed.
+func (a *applicationImpl) Remove(ctx context.Context, request RemoveRequest) ([]deb.PackageKey, error) {
+	var removed []deb.PackageKey
+	err := a.withLock(ctx, func(ctx context.Context) error {
+		var err error
+		removed, err = a.remove(ctx, request)
+		return err
+	})
+	return removed, err
+}
+
+func (a *applicationImpl) remove(ctx context.Context, request RemoveRequest) ([]deb.PackageKey, error) {
+	if err := a.checkSigning(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := a.enforcePolicy(ctx); err != nil {
+		return nil, err
+	}
+
+	indices, err := a.listPackagesIndices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The updates may run again on fresh stanzas, so only keep what the last run removed
+	var updates []indexUpdate
+	removedByIndex := make(map[packagesIndex][]deb.PackageRecord)
+	for _, index := range indices {
+		if index.Component != a.config.Component {
+			continue
+		}
+		updates = append(updates, indexUpdate{index: index, update: func(records []deb.PackageRecord) ([]deb.PackageRecord, bool, error) {
+			var indexRemoved []deb.PackageRecord
+			kept := records[:0]
+			for _, record := range records {
+				if request.matches(index.Architecture, record) {
+					indexRemoved = append(indexRemoved, record)
+					continue
+				}
+				kept = append(kept, record)
+			}
+			removedByIndex[index] = indexRemoved
+			return kept, len(indexRemoved) > 0, nil
+		}})
+	}
+
+	written, err := a.updatePackagesIndices(ctx, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []deb.PackageKey
+	var poolFiles []string
+	for _, index := range written {
+		for _, record := range removedByIndex[index] {
+			a.logger.Infof("Removed %s from %s", record.Key, index.path(a.config.Archive))
+			// An Architecture: all stanza is removed from every index but reported once
+			if !slices.Contains(removed, record.Key) {
+				removed = append(removed, record.Key)
+			}
+			poolFiles = appendMissing(poolFiles, poolFilename(record))
+		}
+	}
+
+	if len(removed) == 0 {
+		if request.Version != "" {
+			return nil, fmt.Errorf("%s (%s %s): %w", request.PackageName, request.relation(), request.Version, ErrPackageNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", request.PackageName, ErrPackageNotFound)
+	}
+
+	if err := a.UploadSuiteReleaseFile(ctx, a.suiteReleasePath(), nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to upload suite-level Release file: %w", err)
+	}
+
+	if request.DeletePool {
+		if err := a.deleteUnreferencedPoolFiles(ctx, poolFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	return removed, nil
+}
+
+// deleteUnreferencedPoolFiles deletes the given pool files that no current Packages
+// index of any suite refers to. The kept by-hash generations are not consulted: the
+// previous generation of every changed index still lists the dropped stanzas, so a
+// file would otherwise never be deleted.
+func (a *applicationImpl) deleteUnreferencedPoolFiles(ctx context.Context, poolFiles []string) error {
+	referenced, err := a.referencedPoolFiles(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	for _, poolFile := range poolFiles {
+		if poolFile == "" {
+			continue
+		}
+		if referenced[poolFile] {
+			a.logger.Infof("Keeping %s; it is still referenced by an index", poolFile)
+			continue
+		}
+		if err := a.storage.Delete(ctx, poolFile); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", poolFile, err)
+		}
+		a.logger.Infof("Deleted %s", poolFile)
+	}
+
+	return nil
+}
+
+// referencedPoolFiles returns the pool files that the stanzas of the Packages indices
+// of every suite below dists/ refer to. Every binary-<arch> directory holding index
+// files is read with readIndexRecords, so a missing plain Packages file is replaced by
+// its fallbacks, and a directory none of whose files can be read fails the collection
+// rather than being taken for empty. With byHash, the Packages files of the by-hash/
+// generations kept for each index are included, since clients holding an older suite
+// Release file may still fetch them.
+func (a *applicationImpl) referencedPoolFiles(ctx context.Context, byHash bool) (map[string]bool, error) {
+	objects, err := a.storage.List(ctx, "dists/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indices: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, dir := range indexDirs(objects) {
+		records, err := a.readIndexRecords(ctx, filepath.Join(dir, "Packages"))
+		if err != nil {
+			return nil, err
+		}
+		addPoolReferences(referenced, records)
+		count := len(records)
+		if byHash {
+			byHashCount, err := a.addByHashPoolReferences(ctx, referenced, dir)
+			if err != nil {
+				return nil, err
+			}
+			count += byHashCount
+		}
+		a.logger.Debugf("Collected %d pool references from %s", count, dir)
+	}
+
+	return referenced, nil
+}
+
+// indexDirs returns the sorted binary-<arch> directories, such as
+// dists/stable/main/binary-amd64, that hold an index file or by-hash/ copies among
+// the objects listed below dists/.
+func indexDirs(objects []storage.ObjectInfo) []string {
+	dirs := make(map[string]bool)
+	for _, object := range objects {
+		// Keys look like dists/<suite>/<component>/binary-<arch>/Packages
+		suite, relativePath, found := strings.Cut(strings.TrimPrefix(object.Key, "dists/"), "/")
+		if !found {
+			continue
+		}
+		if dir, _, found := strings.Cut(relativePath, "/by-hash/"); found {
+			relativePath = filepath.Join(dir, "Packages")
+		}
+		if isSuiteIndex(relativePath) {
+			dirs[filepath.Join("dists", suite, filepath.Dir(relativePath))] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// addByHashPoolReferences adds the pool files of the stanzas of the Packages files of
+// the by-hash/ generations kept for the index directory dir to referenced, and
+// returns the number of stanzas.
+func (a *applicationImpl) addByHashPoolReferences(ctx context.Context, referenced map[string]bool, dir string) (int, error) {
+	generations, err := a.readByHashGenerations(ctx, dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read by-hash generations of %s: %w", dir, err)
+	}
+
+	count := 0
+	for _, generation := range generations {
+		if generation.Packages == "" {
+			continue
+		}
+		records, _, err := a.readPackagesFile(ctx, filepath.Join(dir, generation.Packages), "")
+		if err != nil {
+			return 0, err
+		}
+		addPoolReferences(referenced, records)
+		count += len(records)
+	}
+	return count, nil
+}
+
+// addPoolReferences adds the pool files of records to referenced.
+func addPoolReferences(referenced map[string]bool, records []deb.PackageRecord) {
+	for _, record := range records {
+		referenced[poolFilename(record)] = true
+	}
+}
+
+// readIndexRecords returns the stanzas of the Packages index at path. A missing or
+// corrupt Packages file is read from the newest by-hash generation of its directory,
+// which holds the same stanzas, or else from the compressed variants next to it.
+func (a *applicationImpl) readIndexRecords(ctx context.Context, path string) ([]deb.PackageRecord, error) {
+	records, found, err := a.readPackagesFile(ctx, path, "")
+	if found && err == nil {
+		return records, nil
+	}
+	return a.readIndexFallback(ctx, path, err)
+}
+
+// readIndexFallback reads the stanzas of the index whose Packages file at path is
+// missing, or could not be read with err, from the newest by-hash generation of its
+// directory or else from its compressed variants. A directory without any file has
+// no stanzas. One that holds files none of which can be read fails with
+// errInvalidPackagesIndex, as taking it for empty would drop its packages.
+func (a *applicationImpl) readIndexFallback(ctx context.Context, path string, err error) ([]deb.PackageRecord, error) {
+	var failures []error
+	if err != nil {
+		failures = append(failures, err)
+	}
+
+	type candidate struct{ path, compression string }
+	var candidates []candidate
+	dir := filepath.Dir(path)
+	generations, err := a.readByHashGenerations(ctx, dir)
+	if err != nil {
+		failures = append(failures, fmt.Errorf("failed to read by-hash generations of %s: %w", dir, err))
+	}
+	if len(generations) > 0 && generations[len(generations)-1].Packages != "" {
+		candidates = append(candidates, candidate{path: filepath.Join(dir, generations[len(generations)-1].Packages)})
+	}
+	for _, suffix := range deb.IndexCompressionSuffixes() {
+		if suffix != "" {
+			candidates = append(candidates, candidate{path: path + suffix, compression: suffix})
+		}
+	}
+
+	for _, candidate := range candidates {
+		records, found, err := a.readPackagesFile(ctx, candidate.path, candidate.compression)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		if found {
+			a.logger.Warnf("Reading the stanzas of %s from %s", path, candidate.path)
+			return records, nil
+		}
+	}
+
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("no readable Packages index in %s: %w: %w", dir, errInvalidPackagesIndex, errors.Join(failures...))
+	}
+	objects, err := a.storage.List(ctx, dir+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	if len(objects) > 0 {
+		return nil, fmt.Errorf("no Packages index left in %s: %w", dir, errInvalidPackagesIndex)
+	}
+	// The suite has no index of this architecture
+	return nil, nil
+}
+
+// readPackagesFile downloads the Packages file at path, compressed with the given
+// suffix, and returns its stanzas. It reports whether the file exists.
+func (a *applicationImpl) readPackagesFile(ctx context.Context, path, compression string) ([]deb.PackageRecord, bool, error) {
+	var compressed bytes.Buffer
+	err := a.storage.DownloadFile(ctx, path, &compressed)
+	if storage.IsNotFoundError(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to download %s: %w", path, err)
+	}
+
+	decompressor, err := deb.NewDecompressor(&compressed, compression)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	defer decompressor.Close()
+	var packagesBuffer bytes.Buffer
+	if _, err := io.Copy(&packagesBuffer, decompressor); err != nil {
+		return nil, true, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+
+	records, err := deb.ParsePackagesFile(packagesBuffer.String())
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return records, true, nil
+}
+
+// ReindexRequest configures Reindex.
+type ReindexRequest struct {
+	// FromPool rebuilds the Packages indices of the configured component from the .deb
+	// files below pool/<component>/ that they refer to, instead of their current
+	// stanzas. An index that is missing or corrupt is read from its newest by-hash
+	// generation to find them.
+	FromPool bool
+	// WholePool rebuilds from every .deb file below pool/<component>/. Since the pool is
+	// shared by every suite, the rebuilt indices then also list the packages of other
+	// suites and the versions removed or pruned from this one.
+	WholePool bool
+	// CachePath is a local file caching the stanzas of pool files between rebuilds
+	// from the pool, so unchanged files are not downloaded again. Empty disables it.
+	CachePath string
+}
+
+// Reindex rewrites every Packages index of the suite from its current stanzas, or
+// those of the configured component from the pool, regenerating its compressed
+// variants and the Release files alongside it, while holding the suite lock.
+func (a *applicationImpl) Reindex(ctx context.Context, request ReindexRequest) error {
+	return a.withLock(ctx, func(ctx context.Context) error {
+		if err := a.checkSigning(ctx); err != nil {
+			return err
+		}
+		policy, err := a.enforcePolicy(ctx)
+		if err != nil {
+			return err
+		}
+		if request.FromPool {
+			return a.rebuildFromPool(ctx, request, policy)
+		}
+		return a.reindex(ctx)
+	})
+}
+
+func (a *applicationImpl) reindex(ctx context.Context) error {
+	indices, err := a.listPackagesIndices(ctx)
+	if err != nil {
+		return err
+	}
+
+	updates := make([]indexUpdate, len(indices))
+	for i, index := range indices {
+		updates[i] = indexUpdate{index: index, update: func(records []deb.PackageRecord) ([]deb.PackageRecord, bool, error) {
+			return records, true, nil
+		}}
+	}
+	if _, err := a.updatePackagesIndices(ctx, updates); err != nil {
+		return err
+	}
+
+	if err := a.UploadSuiteReleaseFile(ctx, a.suiteReleasePath(), nil, nil); err != nil {
+		return fmt.Errorf("failed to upload suite-level Release file: %w", err)
+	}
+
+	a.logger.Infof("Reindexed %d Packages indices", len(indices))
+	return nil
+}
+
+// listPackagesIndices returns the Packages indices stored below the suite.
+func (a *applicationImpl) listPackagesIndices(ctx context.Context) ([]packagesIndex, error) {
+	paths, err := a.listSuiteIndices(ctx, a.suiteDir())
+	if err != nil {
+		return nil, err
+	}
+
+	var indices []packagesIndex
+	for _, path := range paths {
+		if component, architecture, ok := packagesIndexLocation(path); ok {
+			indices = append(indices, packagesIndex{Component: component, Architecture: architecture})
+		}
+	}
+
+	return indices, nil
+}
+
+// readPackagesIndex downloads and parses the Packages file of index.
+func (a *applicationImpl) readPackagesIndex(ctx context.Context, index packagesIndex) ([]deb.PackageRecord, error) {
+	records, _, err := a.downloadPackagesRecords(ctx, index.path(a.config.Archive))
+	return records, err
+}
@@ -0,0 +1,235 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNoRetention is returned by Prune when no retention policy is configured.
+var ErrNoRetention = errors.New("no retention policy configured")
+
+// Retention limits the versions of each package kept in the Packages indices of the
+// configured suite and component. Versions are ordered with Debian version rules,
+// and the newest version of a package is always kept.
+type Retention struct {
+	// KeepVersions is the number of newest versions of each package that are kept.
+	// Zero keeps every version.
+	KeepVersions int
+	// MaxAge drops versions whose pool file was uploaded longer ago than this. Zero
+	// keeps versions of any age.
+	MaxAge time.Duration
+}
+
+// storedRetention is a Retention as stored in the repository policy, with MaxAge in
+// duration syntax such as 720h0m0s.
+type storedRetention struct {
+	KeepVersions int    `json:"keepVersions,omitempty"`
+	MaxAge       string `json:"maxAge,omitempty"`
+}
+
+// Enabled reports whether the policy drops anything at all.
+func (r Retention) Enabled() bool {
+	return r.KeepVersions > 0 || r.MaxAge > 0
+}
+
+func (r Retention) String() string {
+	var limits []string
+	if r.KeepVersions > 0 {
+		limits = append(limits, fmt.Sprintf("keep %d versions", r.KeepVersions))
+	}
+	if r.MaxAge > 0 {
+		limits = append(limits, fmt.Sprintf("max age %s", r.MaxAge))
+	}
+	if len(limits) == 0 {
+		return "keep all"
+	}
+	return strings.Join(limits, ", ")
+}
+
+func (r Retention) MarshalJSON() ([]byte, error) {
+	stored := storedRetention{KeepVersions: r.KeepVersions}
+	if r.MaxAge > 0 {
+		stored.MaxAge = r.MaxAge.String()
+	}
+	return json.Marshal(stored)
+}
+
+func (r *Retention) UnmarshalJSON(data []byte) error {
+	var stored storedRetention
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	r.KeepVersions, r.MaxAge = stored.KeepVersions, 0
+	if stored.MaxAge != "" {
+		maxAge, err := time.ParseDuration(stored.MaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid maxAge: %w", err)
+		}
+		r.MaxAge = maxAge
+	}
+	return nil
+}
+
+// retention returns the retention policy of the configured suite and component: the
+// one of the run when it has one, or else the one stored in policy.
+func (a *applicationImpl) retention(policy RepositoryPolicy) Retention {
+	if a.config.Retention.Enabled() {
+		return a.config.Retention
+	}
+	return policy.ComponentRetention(a.config.Archive, a.config.Component)
+}
+
+// Prune applies the retention policy of the run, or else the one stored for the
+// configured component, to the Packages indices of the component, regenerating the
+// Release files when stanzas were dropped. With deletePool, the pool files of the
+// pruned stanzas are deleted once no current index of any suite refers to them. It
+// returns the keys of the pruned stanzas. The suite lock is held while the indices are
+// changed.
+func (a *applicationImpl) Prune(ctx context.Context, deletePool bool) ([]deb.PackageKey, error) {
+	var pruned []deb.PackageKey
+	err := a.withLock(ctx, func(ctx context.Context) error {
+		var err error
+		pruned, err = a.prune(ctx, deletePool)
+		return err
+	})
+	return pruned, err
+}
+
+func (a *applicationImpl) prune(ctx context.Context, deletePool bool) ([]deb.PackageKey, error) {
+	if err := a.checkSigning(ctx); err != nil {
+		return nil, err
+	}
+	policy, err := a.enforcePolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	retention := a.retention(policy)
+	if !retention.Enabled() {
+		return nil, ErrNoRetention
+	}
+
+	indices, err := a.listPackagesIndices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	retain, err := a.retentionFilter(ctx, retention)
+	if err != nil {
+		return nil, err
+	}
+
+	// The updates may run again on fresh stanzas, so only keep what the last run pruned
+	var updates []indexUpdate
+	prunedByIndex := make(map[packagesIndex][]deb.PackageRecord)
+	for _, index := range indices {
+		if index.Component != a.config.Component {
+			continue
+		}
+		updates = append(updates, indexUpdate{index: index, update: func(records []deb.PackageRecord) ([]deb.PackageRecord, bool, error) {
+			kept, indexPruned := retain(records, nil)
+			prunedByIndex[index] = indexPruned
+			return kept, len(indexPruned) > 0, nil
+		}})
+	}
+
+	written, err := a.updatePackagesIndices(ctx, updates)
+	if err != nil {
+		return nil, err
+	}
+	pruned, poolFiles := a.logPruned(written, prunedByIndex)
+	if len(pruned) == 0 {
+		a.logger.Infof("Nothing to prune")
+		return nil, nil
+	}
+
+	if err := a.UploadSuiteReleaseFile(ctx, a.suiteReleasePath(), nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to upload suite-level Release file: %w", err)
+	}
+
+	if deletePool {
+		if err := a.deleteUnreferencedPoolFiles(ctx, poolFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	return pruned, nil
+}
+
+// logPruned logs the stanzas pruned from the written indices and returns their keys
+// and pool files.
+func (a *applicationImpl) logPruned(written []packagesIndex, prunedByIndex map[packagesIndex][]deb.PackageRecord) ([]deb.PackageKey, []string) {
+	var pruned []deb.PackageKey
+	var poolFiles []string
+	for _, index := range written {
+		for _, record := range prunedByIndex[index] {
+			a.logger.Infof("Pruned %s from %s", record.Key, index.path(a.config.Archive))
+			pruned = append(pruned, record.Key)
+			poolFiles = appendMissing(poolFiles, poolFilename(record))
+		}
+	}
+	return pruned, poolFiles
+}
+
+// retentionFilter returns a function that splits the stanzas of an index into the
+// ones retention keeps and the ones it prunes, never pruning the keys in keep. When
+// MaxAge is set, the upload times of the pool files of the component are listed once
+// up front.
+func (a *applicationImpl) retentionFilter(ctx context.Context, retention Retention) (func(records []deb.PackageRecord, keep map[deb.PackageKey]bool) ([]deb.PackageRecord, []deb.PackageRecord), error) {
+	var uploaded map[string]time.Time
+	if retention.MaxAge > 0 {
+		prefix := "pool/" + a.config.Component + "/"
+		objects, err := a.storage.List(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pool files: %w", err)
+		}
+		uploaded = make(map[string]time.Time, len(objects))
+		for _, object := range objects {
+			uploaded[object.Key] = object.LastModified
+		}
+	}
+	now := time.Now()
+
+	return func(records []deb.PackageRecord, keep map[deb.PackageKey]bool) ([]deb.PackageRecord, []deb.PackageRecord) {
+		return applyRetention(retention, records, keep, uploaded, now)
+	}, nil
+}
+
+// applyRetention splits records into the ones retention keeps and the ones it
+// prunes. Versions of a package are ranked newest first; a version is pruned when
+// its rank is KeepVersions or more, or when it is not the newest and its pool file
+// was uploaded more than MaxAge before now. A pool file missing from uploaded is
+// taken to be new. The records whose key is in keep are never pruned.
+func applyRetention(retention Retention, records []deb.PackageRecord, keep map[deb.PackageKey]bool, uploaded map[string]time.Time, now time.Time) ([]deb.PackageRecord, []deb.PackageRecord) {
+	versions := make(map[string][]string)
+	for _, record := range records {
+		if !slices.Contains(versions[record.Key.PackageName], record.Key.Version) {
+			versions[record.Key.PackageName] = append(versions[record.Key.PackageName], record.Key.Version)
+		}
+	}
+	for _, packageVersions := range versions {
+		slices.SortFunc(packageVersions, func(a, b string) int {
+			return deb.CompareVersions(b, a)
+		})
+	}
+
+	var kept, pruned []deb.PackageRecord
+	for _, record := range records {
+		rank := slices.Index(versions[record.Key.PackageName], record.Key.Version)
+		expired := false
+		if uploadedAt, ok := uploaded[poolFilename(record)]; ok && retention.MaxAge > 0 {
+			expired = now.Sub(uploadedAt) > retention.MaxAge
+		}
+
+		if rank > 0 && !keep[record.Key] && ((retention.KeepVersions > 0 && rank >= retention.KeepVersions) || expired) {
+			pruned = append(pruned, record)
+			continue
+		}
+		kept = append(kept, record)
+	}
+	return kept, pruned
+}
@@ -0,0 +1,251 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/storage"
+	"path/filepath"
+	"slices"
+)
+
+// defaultIndexCompressions are the Packages variants written when Config.Compressions
+// is not set: the uncompressed file and Packages.gz.
+var defaultIndexCompressions = []string{"", ".gz"}
+
+// indexFile is a file of a binary-<arch> index directory, such as Packages,
+// Packages.gz or Release.
+type indexFile struct {
+	path string
+	data *bytes.Buffer
+}
+
+// stagedIndex is a Packages index whose new files have been written below by-hash/
+// but not yet under their plain names.
+type stagedIndex struct {
+	packagesPath string
+	release      releaseRenderer
+	update       packagesUpdate
+	// rebuild ignores a Packages file that cannot be parsed, see indexUpdate.
+	rebuild bool
+	// etags are the ETags of the files of the index directory when it was staged, by
+	// path; the Packages file has the ETag it was read with. Each file is only
+	// replaced if it is unchanged.
+	etags map[string]string
+	// files are the files of the index directory, Packages first.
+	files []indexFile
+}
+
+// indexUpdate is an update of the Packages index of one component and architecture.
+type indexUpdate struct {
+	index  packagesIndex
+	update packagesUpdate
+	// rebuild marks an update that does not depend on the current stanzas, so a
+	// Packages file that cannot be parsed is replaced rather than failing the update.
+	rebuild bool
+}
+
+// releaseRenderer renders the architecture-specific Release file of a Packages index
+// from its Packages file and compressed variants.
+type releaseRenderer func(files []indexFile) *bytes.Buffer
+
+// updatePackagesIndices applies updates to their Packages indices in stages so that
+// apt never sees a half-written suite: the new files of every index are first
+// written to content-addressed by-hash/ paths, which the current suite Release file
+// does not refer to, and then under their plain names. The caller writes the suite
+// Release file last. It returns the indices that were written.
+func (a *applicationImpl) updatePackagesIndices(ctx context.Context, updates []indexUpdate) ([]packagesIndex, error) {
+	staged := make([]*stagedIndex, len(updates))
+	for i, update := range updates {
+		var err error
+		staged[i], err = a.stageIndex(ctx, update.index.path(a.config.Archive), a.packageReleaseRenderer(update.index), update.update, update.rebuild)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var written []packagesIndex
+	for i, update := range updates {
+		if staged[i] == nil {
+			continue
+		}
+		committed, err := a.commitIndex(ctx, staged[i])
+		if err != nil {
+			return nil, err
+		}
+		if committed != nil {
+			written = append(written, update.index)
+		}
+	}
+
+	return written, nil
+}
+
+// packageReleaseRenderer returns the renderer of the architecture-specific Release
+// file of index.
+func (a *applicationImpl) packageReleaseRenderer(index packagesIndex) releaseRenderer {
+	return func(files []indexFile) *bytes.Buffer {
+		return a.renderPackageReleaseFile(index.Component, index.Architecture, files)
+	}
+}
+
+// stageIndex reads the Packages file at packagesPath, applies update and writes the
+// resulting Packages file, its compressed variants and Release file below by-hash/.
+// It returns nil when update left the index alone. With rebuild, a Packages file
+// that cannot be parsed is treated as empty.
+func (a *applicationImpl) stageIndex(ctx context.Context, packagesPath string, release releaseRenderer, update packagesUpdate, rebuild bool) (*stagedIndex, error) {
+	records, etag, err := a.downloadPackagesRecords(ctx, packagesPath)
+	if rebuild && errors.Is(err, errInvalidPackagesIndex) {
+		a.logger.WithError(err).Warnf("Replacing corrupt %s", packagesPath)
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records, changed, err := update(records)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	files, err := a.renderPackagesIndex(packagesPath, records)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(packagesPath)
+	etags, err := a.indexETags(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	etags[packagesPath] = etag
+	staged := &stagedIndex{
+		packagesPath: packagesPath,
+		release:      release,
+		update:       update,
+		rebuild:      rebuild,
+		etags:        etags,
+		files:        files,
+	}
+	staged.files = append(staged.files, indexFile{path: filepath.Join(dir, "Release"), data: release(files)})
+
+	for _, file := range staged.files {
+		if err := a.uploadByHash(ctx, dir, file.data); err != nil {
+			return nil, fmt.Errorf("failed to upload %s by hash: %w", file.path, err)
+		}
+	}
+
+	return staged, nil
+}
+
+// indexETags returns the ETags of the objects of the index directory dir, by path.
+// Missing files have no entry, which UploadBufferIfMatch reads as "must not exist".
+func (a *applicationImpl) indexETags(ctx context.Context, dir string) (map[string]string, error) {
+	objects, err := a.storage.List(ctx, dir+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	etags := make(map[string]string, len(objects))
+	for _, object := range objects {
+		etags[object.Key] = object.ETag
+	}
+	return etags, nil
+}
+
+// commitIndex writes the files of a staged index under their plain names. When a
+// file of the index was changed by another writer since it was staged, the index is
+// staged again from the new contents, up to maxPackagesUpdateAttempts times. It
+// returns the index as written, or nil when the update no longer changes it.
+func (a *applicationImpl) commitIndex(ctx context.Context, staged *stagedIndex) (*stagedIndex, error) {
+	for attempt := 1; ; attempt++ {
+		err := a.writeStagedIndex(ctx, staged)
+		if err == nil {
+			a.recordByHashGeneration(ctx, staged)
+			return staged, nil
+		}
+		if !storage.IsPreconditionFailedError(err) {
+			return nil, err
+		}
+		if attempt == maxPackagesUpdateAttempts {
+			return nil, fmt.Errorf("%s was modified concurrently %d times: %w", staged.packagesPath, attempt, err)
+		}
+		a.logger.Warnf("%s was modified concurrently; retrying (attempt %d of %d)", staged.packagesPath, attempt+1, maxPackagesUpdateAttempts)
+
+		staged, err = a.stageIndex(ctx, staged.packagesPath, staged.release, staged.update, staged.rebuild)
+		if err != nil || staged == nil {
+			return nil, err
+		}
+	}
+}
+
+// writeStagedIndex writes the files of staged under their plain names, Packages
+// first. Every file is only written if it still has the ETag it was staged with, so
+// a writer that lost a race never overwrites the compressed variants or Release file
+// of the winner. Compressed variants that are no longer configured are deleted, since
+// they would be listed with stale contents.
+func (a *applicationImpl) writeStagedIndex(ctx context.Context, staged *stagedIndex) error {
+	for _, file := range staged.files {
+		if err := a.uploadIfMatch(ctx, file.path, file.data, staged.etags[file.path]); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", file.path, err)
+		}
+	}
+
+	for _, suffix := range deb.IndexCompressionSuffixes() {
+		if suffix == "" || slices.Contains(a.indexCompressions(), suffix) {
+			continue
+		}
+		if err := a.storage.Delete(ctx, staged.packagesPath+suffix); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", staged.packagesPath+suffix, err)
+		}
+	}
+	return nil
+}
+
+// uploadIfMatch writes buffer to path if the object still has etag, an empty etag
+// meaning it must not exist. Without conditional writes it writes unconditionally.
+func (a *applicationImpl) uploadIfMatch(ctx context.Context, path string, buffer *bytes.Buffer, etag string) error {
+	if a.config.NoConditionalWrites {
+		return a.storage.UploadBuffer(ctx, path, buffer)
+	}
+	return a.storage.UploadBufferIfMatch(ctx, path, buffer, etag)
+}
+
+// renderPackagesIndex renders records as the Packages file at packagesPath followed
+// by its configured compressed variants. The uncompressed file is always written,
+// since it is the one indices are read from.
+func (a *applicationImpl) renderPackagesIndex(packagesPath string, records []deb.PackageRecord) ([]indexFile, error) {
+	packagesBuffer := bytes.NewBufferString(deb.CreatePackagesFile(records))
+	files := []indexFile{{path: packagesPath, data: packagesBuffer}}
+
+	for _, suffix := range a.indexCompressions() {
+		if suffix == "" {
+			continue
+		}
+		compressed, err := deb.Compress(packagesBuffer.Bytes(), suffix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", packagesPath+suffix, err)
+		}
+		files = append(files, indexFile{path: packagesPath + suffix, data: compressed})
+	}
+
+	return files, nil
+}
+
+// indexCompressions returns the suffixes of the configured Packages variants.
+func (a *applicationImpl) indexCompressions() []string {
+	if len(a.config.Compressions) == 0 {
+		return defaultIndexCompressions
+	}
+	return a.config.Compressions
+}
+
+// listedIndexFile reports whether the index file at path is listed in Release files.
+// The uncompressed Packages file is only listed when it is a configured variant.
+func (a *applicationImpl) listedIndexFile(path string) bool {
+	return filepath.Base(path) != "Packages" || slices.Contains(a.indexCompressions(), "")
+}
@@ -1,38 +1,149 @@
 package application
 
 import (
-	"bytes"
-	"compress/gzip"
 	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/filereader"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 )
 
-func compressGzip(data *bytes.Buffer) (*bytes.Buffer, error) {
-	var buf bytes.Buffer
-	gzWriter := gzip.NewWriter(&buf)
-	_, err := gzWriter.Write(data.Bytes())
-	if err != nil {
-		return nil, err
+// suiteIndexPattern matches index paths relative to a suite directory, such as
+// main/binary-amd64/Packages.gz or main/binary-amd64/Release, and captures the
+// component and architecture.
+var suiteIndexPattern = regexp.MustCompile(`^([^/]+)/binary-([^/]+)/(Packages(\.[a-z0-9]+)?|Release)$`)
+
+// isSuiteIndex reports whether relativePath is an index listed in the suite Release file
+func isSuiteIndex(relativePath string) bool {
+	return suiteIndexPattern.MatchString(relativePath)
+}
+
+// suiteLayout returns the sorted components and architectures that have an index
+// among the given suite-relative index paths.
+func suiteLayout(indices []string) (components, architectures []string) {
+	for _, index := range indices {
+		match := suiteIndexPattern.FindStringSubmatch(index)
+		if match == nil {
+			continue
+		}
+		components = appendMissing(components, match[1])
+		architectures = appendMissing(architectures, match[2])
+	}
+	sort.Strings(components)
+	sort.Strings(architectures)
+	return components, architectures
+}
+
+// packagesIndexLocation returns the component and architecture of a suite-relative
+// path that names an uncompressed Packages index.
+func packagesIndexLocation(relativePath string) (component, architecture string, ok bool) {
+	match := suiteIndexPattern.FindStringSubmatch(relativePath)
+	if match == nil || match[3] != "Packages" {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// poolFilename returns the Filename of a stanza as the key of its pool file. Indices
+// may refer to ./pool/... or /pool/..., which name the same object as pool/...
+func poolFilename(record deb.PackageRecord) string {
+	filename := record.Paragraph.Get("Filename")
+	if filename == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path.Clean(filename), "/")
+}
+
+// appendMissing appends the values that are not yet in list, keeping their order.
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
 	}
-	if err := gzWriter.Close(); err != nil {
-		return nil, err
+	return list
+}
+
+// hashingFile wraps a filereader.File and hashes everything read through it.
+// Seeking back to the start resets the digests, so a retried upload that
+// rewinds the file still yields the checksums of a single full pass.
+type hashingFile struct {
+	filereader.File
+	hasher *deb.Hasher
+}
+
+func newHashingFile(file filereader.File) *hashingFile {
+	return &hashingFile{File: file, hasher: deb.NewHasher()}
+}
+
+func (h *hashingFile) Read(p []byte) (int, error) {
+	n, err := h.File.Read(p)
+	if n > 0 {
+		_, _ = h.hasher.Write(p[:n])
+	}
+	return n, err
+}
+
+func (h *hashingFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := h.File.Seek(offset, whence)
+	if err == nil && pos == 0 {
+		h.hasher.Reset()
+	}
+	return pos, err
+}
+
+// Sum returns the size and digests of the data read since the last rewind.
+func (h *hashingFile) Sum() deb.Checksums {
+	return h.hasher.Sum()
+}
+
+// hashFile computes the checksums of file and rewinds it afterwards.
+func hashFile(file filereader.File) (deb.Checksums, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return deb.Checksums{}, err
+	}
+	hasher := deb.NewHasher()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return deb.Checksums{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return deb.Checksums{}, err
+	}
+	return hasher.Sum(), nil
+}
+
+// metadataKey returns the key identifying the package's stanza in a Packages index.
+func metadataKey(metadata *deb.PackageMetadata) deb.PackageKey {
+	return deb.PackageKey{
+		PackageName:  metadata.PackageName,
+		Version:      metadata.Version,
+		Architecture: metadata.Architecture,
 	}
-	return &buf, nil
 }
 
 func mapMetadataToPackageContents(metadata *deb.PackageMetadata) *deb.PackagesContent {
 	return &deb.PackagesContent{
-		PackageName:   metadata.PackageName,
-		Version:       metadata.Version,
-		Architecture:  metadata.Architecture,
-		Maintainer:    metadata.Maintainer,
-		Description:   metadata.Description,
-		Section:       metadata.Section,
-		Priority:      metadata.Priority,
-		InstalledSize: metadata.InstalledSize,
-		Depends:       metadata.Depends,
-		Recommends:    metadata.Recommends,
-		Suggests:      metadata.Suggests,
-		Conflicts:     metadata.Conflicts,
-		Provides:      metadata.Provides,
+		PackageName:      metadata.PackageName,
+		Version:          metadata.Version,
+		Architecture:     metadata.Architecture,
+		Maintainer:       metadata.Maintainer,
+		Description:      metadata.Description,
+		Section:          metadata.Section,
+		Priority:         metadata.Priority,
+		InstalledSize:    metadata.InstalledSize,
+		Depends:          metadata.Depends,
+		Recommends:       metadata.Recommends,
+		Suggests:         metadata.Suggests,
+		Conflicts:        metadata.Conflicts,
+		Provides:         metadata.Provides,
+		AdditionalFields: metadata.AdditionalFields(),
+		Filename:         metadata.Filename,
+		Size:             metadata.Checksums.Size,
+		MD5sum:           metadata.Checksums.MD5,
+		SHA1:             metadata.Checksums.SHA1,
+		SHA256:           metadata.Checksums.SHA256,
 	}
 }
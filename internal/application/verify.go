@@ -0,0 +1,143 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pavliha/aptforge/internal/deb"
+	"github.com/pavliha/aptforge/internal/deb822"
+	"github.com/pavliha/aptforge/internal/storage"
+	"path/filepath"
+	"strconv"
+)
+
+// Problem is an inconsistency found by Verify.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// Verify checks that the suite is consistent as apt would see it: every index listed
+// in the suite Release file exists with the listed size and SHA256, every index below
+// the suite is listed, and every stanza refers to a pool file of the recorded size.
+// It returns the problems found; an error is only returned when the check itself fails.
+func (a *applicationImpl) Verify(ctx context.Context) ([]Problem, error) {
+	problems, err := a.verifySuiteRelease(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	poolProblems, err := a.verifyPoolReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, poolProblems...)
+
+	a.logger.Infof("Verification found %d problems", len(problems))
+	return problems, nil
+}
+
+// verifySuiteRelease compares the SHA256 section of the suite Release file with the
+// indices stored below the suite.
+func (a *applicationImpl) verifySuiteRelease(ctx context.Context) ([]Problem, error) {
+	releasePath := a.suiteReleasePath()
+	suiteDir := a.suiteDir()
+
+	indices, err := a.listSuiteIndices(ctx, suiteDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var releaseBuffer bytes.Buffer
+	err = a.storage.DownloadFile(ctx, releasePath, &releaseBuffer)
+	if storage.IsNotFoundError(err) {
+		return []Problem{{Path: releasePath, Message: "suite Release file is missing"}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", releasePath, err)
+	}
+
+	paragraphs, err := deb822.Parse(&releaseBuffer)
+	if err != nil || len(paragraphs) != 1 {
+		return []Problem{{Path: releasePath, Message: fmt.Sprintf("suite Release file cannot be parsed: %v", err)}}, nil
+	}
+	listed, err := deb.ParseChecksums(paragraphs[0].Get("SHA256"))
+	if err != nil {
+		return []Problem{{Path: releasePath, Message: fmt.Sprintf("invalid SHA256 section: %v", err)}}, nil
+	}
+
+	var problems []Problem
+	listedFiles := make(map[string]bool, len(listed))
+	for _, entry := range listed {
+		listedFiles[entry.Filename] = true
+		indexPath := filepath.Join(suiteDir, entry.Filename)
+
+		var indexBuffer bytes.Buffer
+		err := a.storage.DownloadFile(ctx, indexPath, &indexBuffer)
+		if storage.IsNotFoundError(err) {
+			problems = append(problems, Problem{Path: indexPath, Message: "listed in the suite Release file but missing"})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", indexPath, err)
+		}
+
+		checksums := deb.ComputeChecksums(indexBuffer.Bytes())
+		if checksums.Size != entry.Size {
+			problems = append(problems, Problem{Path: indexPath, Message: fmt.Sprintf("size is %d, the suite Release file lists %d", checksums.Size, entry.Size)})
+		} else if checksums.SHA256 != entry.Checksum {
+			problems = append(problems, Problem{Path: indexPath, Message: "SHA256 does not match the suite Release file"})
+		}
+	}
+
+	for _, index := range indices {
+		if !listedFiles[index] && a.listedIndexFile(index) {
+			problems = append(problems, Problem{Path: filepath.Join(suiteDir, index), Message: "not listed in the suite Release file"})
+		}
+	}
+
+	return problems, nil
+}
+
+// verifyPoolReferences checks that every stanza of every Packages index refers to an
+// existing pool file of the recorded size.
+func (a *applicationImpl) verifyPoolReferences(ctx context.Context) ([]Problem, error) {
+	objects, err := a.storage.List(ctx, "pool/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pool: %w", err)
+	}
+	poolSizes := make(map[string]int64, len(objects))
+	for _, object := range objects {
+		poolSizes[object.Key] = object.Size
+	}
+
+	entries, err := a.List(ctx, PackageFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	for _, entry := range entries {
+		indexPath := packagesIndex{Component: entry.Component, Architecture: entry.Architecture}.path(a.config.Archive)
+		filename := entry.Record.Paragraph.Get("Filename")
+		if filename == "" {
+			problems = append(problems, Problem{Path: indexPath, Message: fmt.Sprintf("%s has no Filename", entry.Record.Key)})
+			continue
+		}
+
+		size, found := poolSizes[poolFilename(entry.Record)]
+		if !found {
+			problems = append(problems, Problem{Path: indexPath, Message: fmt.Sprintf("%s refers to missing pool file %s", entry.Record.Key, filename)})
+			continue
+		}
+		if recorded := entry.Record.Paragraph.Get("Size"); recorded != strconv.FormatInt(size, 10) {
+			problems = append(problems, Problem{Path: indexPath, Message: fmt.Sprintf("%s records size %s but %s is %d bytes", entry.Record.Key, recorded, filename, size)})
+		}
+	}
+
+	return problems, nil
+}
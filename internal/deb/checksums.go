@@ -0,0 +1,75 @@
+package deb
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// Checksums holds the size and digests of a file published in the repository.
+type Checksums struct {
+	Size   int64
+	MD5    string
+	SHA1   string
+	SHA256 string
+	SHA512 string
+}
+
+// Hasher is an io.Writer that computes every digest used by APT in a single pass.
+type Hasher struct {
+	size   int64
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+// NewHasher returns a Hasher ready to receive data.
+func NewHasher() *Hasher {
+	return &Hasher{
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+		sha512: sha512.New(),
+	}
+}
+
+// Write feeds p to all digests. It never returns an error.
+func (h *Hasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	h.sha1.Write(p)
+	h.sha256.Write(p)
+	h.sha512.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+// Reset discards everything written so far.
+func (h *Hasher) Reset() {
+	h.md5.Reset()
+	h.sha1.Reset()
+	h.sha256.Reset()
+	h.sha512.Reset()
+	h.size = 0
+}
+
+// Sum returns the size and hex-encoded digests of the data written so far.
+func (h *Hasher) Sum() Checksums {
+	return Checksums{
+		Size:   h.size,
+		MD5:    fmt.Sprintf("%x", h.md5.Sum(nil)),
+		SHA1:   fmt.Sprintf("%x", h.sha1.Sum(nil)),
+		SHA256: fmt.Sprintf("%x", h.sha256.Sum(nil)),
+		SHA512: fmt.Sprintf("%x", h.sha512.Sum(nil)),
+	}
+}
+
+// ComputeChecksums returns the size and digests of data.
+func ComputeChecksums(data []byte) Checksums {
+	h := NewHasher()
+	_, _ = h.Write(data)
+	return h.Sum()
+}
@@ -0,0 +1,31 @@
+package deb
+
+import (
+	"testing"
+)
+
+func TestComputeChecksums(t *testing.T) {
+	result := ComputeChecksums([]byte("hello\n"))
+
+	expected := Checksums{
+		Size:   6,
+		MD5:    "b1946ac92492d2347c6235b4d2611184",
+		SHA1:   "f572d396fae9206628714fb2ce00f72e94f2258f",
+		SHA256: "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		SHA512: "e7c22b994c59d9cf2b48e549b1e24666636045930d3da7c1acb299d1c3b7f931f94aae41edda2c2b207a36e10f8bcb8d45223e54878f5b316e7ce3b6bc019629",
+	}
+	if result != expected {
+		t.Errorf("ComputeChecksums() = %+v, want %+v", result, expected)
+	}
+}
+
+func TestHasherReset(t *testing.T) {
+	h := NewHasher()
+	_, _ = h.Write([]byte("garbage"))
+	h.Reset()
+	_, _ = h.Write([]byte("hello\n"))
+
+	if h.Sum() != ComputeChecksums([]byte("hello\n")) {
+		t.Errorf("expected Reset to discard previously written data")
+	}
+}
@@ -0,0 +1,167 @@
+package deb
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	dsnetbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Compression suffixes dpkg accepts for the control and data members of a .deb.
+// The empty suffix stands for an uncompressed tarball.
+var (
+	controlCompressions = []string{"", ".gz", ".xz", ".zst"}
+	dataCompressions    = []string{"", ".gz", ".xz", ".zst", ".bz2", ".lzma"}
+)
+
+// indexCompressions are the compressions a Packages index can be published with,
+// by name, with the suffix of the compressed file. "none" is the uncompressed index.
+var indexCompressions = []struct {
+	name   string
+	suffix string
+}{
+	{name: "none", suffix: ""},
+	{name: "gz", suffix: ".gz"},
+	{name: "xz", suffix: ".xz"},
+	{name: "bz2", suffix: ".bz2"},
+	{name: "zst", suffix: ".zst"},
+}
+
+// IndexCompressionSuffix returns the file suffix of the named index compression.
+func IndexCompressionSuffix(name string) (string, error) {
+	for _, compression := range indexCompressions {
+		if compression.name == name {
+			return compression.suffix, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported index compression %q (supported: %s)", name, strings.Join(IndexCompressionNames(), ", "))
+}
+
+// IndexCompressionNames returns the names of the supported index compressions.
+func IndexCompressionNames() []string {
+	names := make([]string, len(indexCompressions))
+	for i, compression := range indexCompressions {
+		names[i] = compression.name
+	}
+	return names
+}
+
+// IndexCompressionSuffixes returns the file suffixes of every supported index compression.
+func IndexCompressionSuffixes() []string {
+	suffixes := make([]string, len(indexCompressions))
+	for i, compression := range indexCompressions {
+		suffixes[i] = compression.suffix
+	}
+	return suffixes
+}
+
+// memberName returns the name of an ar member without the trailing slash some ar
+// implementations append.
+func memberName(name string) string {
+	return strings.TrimSuffix(name, "/")
+}
+
+// tarMemberCompression reports whether name is base+".tar" followed by one of the
+// allowed compression suffixes, and returns that suffix.
+func tarMemberCompression(name, base string, allowed []string) (string, bool) {
+	compression, found := strings.CutPrefix(memberName(name), base+".tar")
+	if !found || !slices.Contains(allowed, compression) {
+		return "", false
+	}
+	return compression, true
+}
+
+// controlMemberCompression reports whether name is a control tarball.
+func controlMemberCompression(name string) (string, bool) {
+	return tarMemberCompression(name, "control", controlCompressions)
+}
+
+// dataMemberCompression reports whether name is a data tarball.
+func dataMemberCompression(name string) (string, bool) {
+	return tarMemberCompression(name, "data", dataCompressions)
+}
+
+// NewDecompressor returns a reader that decompresses r according to a compression
+// suffix, such as that of a .deb member or one of IndexCompressionSuffixes.
+func NewDecompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "":
+		return io.NopCloser(r), nil
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".xz":
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case ".zst":
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader.IOReadCloser(), nil
+	case ".bz2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case ".lzma":
+		lzmaReader, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(lzmaReader), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+}
+
+// Compress compresses data with the compression of the given file suffix, one of
+// IndexCompressionSuffixes. The empty suffix returns data unchanged.
+func Compress(data []byte, compression string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	writer, err := newCompressor(&buf, compression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// newCompressor returns a writer that compresses into w according to a compression suffix.
+func newCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "":
+		return nopWriteCloser{w}, nil
+	case ".gz":
+		// The header is left without a name and modification time, so the output
+		// only depends on the input
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case ".xz":
+		return xz.NewWriter(w)
+	case ".bz2":
+		return dsnetbzip2.NewWriter(w, &dsnetbzip2.WriterConfig{Level: dsnetbzip2.BestCompression})
+	case ".zst":
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
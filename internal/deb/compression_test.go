@@ -0,0 +1,148 @@
+package deb
+
+import (
+	"bytes"
+	"github.com/ulikunitz/xz/lzma"
+	"io"
+	"testing"
+)
+
+func TestMemberCompression(t *testing.T) {
+	tests := []struct {
+		name                string
+		member              string
+		expectedControl     bool
+		expectedData        bool
+		expectedCompression string
+	}{
+		{name: "uncompressed control", member: "control.tar", expectedControl: true, expectedCompression: ""},
+		{name: "gzip control", member: "control.tar.gz", expectedControl: true, expectedCompression: ".gz"},
+		{name: "zstd control", member: "control.tar.zst", expectedControl: true, expectedCompression: ".zst"},
+		{name: "bzip2 control is not allowed", member: "control.tar.bz2"},
+		{name: "xz data", member: "data.tar.xz", expectedData: true, expectedCompression: ".xz"},
+		{name: "bzip2 data", member: "data.tar.bz2", expectedData: true, expectedCompression: ".bz2"},
+		{name: "lzma data with GNU slash", member: "data.tar.lzma/", expectedData: true, expectedCompression: ".lzma"},
+		{name: "unknown data compression", member: "data.tar.lz4"},
+		{name: "debian-binary", member: "debian-binary"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controlCompression, isControl := controlMemberCompression(tt.member)
+			dataCompression, isData := dataMemberCompression(tt.member)
+
+			if isControl != tt.expectedControl {
+				t.Errorf("controlMemberCompression(%q) = %v, want %v", tt.member, isControl, tt.expectedControl)
+			}
+			if isData != tt.expectedData {
+				t.Errorf("dataMemberCompression(%q) = %v, want %v", tt.member, isData, tt.expectedData)
+			}
+			if isControl && controlCompression != tt.expectedCompression {
+				t.Errorf("expected control compression %q, got %q", tt.expectedCompression, controlCompression)
+			}
+			if isData && dataCompression != tt.expectedCompression {
+				t.Errorf("expected data compression %q, got %q", tt.expectedCompression, dataCompression)
+			}
+		})
+	}
+}
+
+func TestNewDecompressorLzma(t *testing.T) {
+	compressed := new(bytes.Buffer)
+	writer, err := lzma.NewWriter(compressed)
+	if err != nil {
+		t.Fatalf("failed to create lzma writer: %v", err)
+	}
+	_, _ = writer.Write([]byte("payload"))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close lzma writer: %v", err)
+	}
+
+	reader, err := NewDecompressor(compressed, ".lzma")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("expected 'payload', got %q", data)
+	}
+}
+
+func TestNewDecompressorUnsupported(t *testing.T) {
+	_, err := NewDecompressor(bytes.NewReader(nil), ".lz4")
+	if err == nil || err.Error() != "unsupported compression: .lz4" {
+		t.Errorf("expected unsupported compression error, got %v", err)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("Package: aptforge\nVersion: 1.0\n\n"), 100)
+
+	for _, suffix := range IndexCompressionSuffixes() {
+		t.Run("suffix "+suffix, func(t *testing.T) {
+			compressed, err := Compress(payload, suffix)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if suffix != "" && compressed.Len() >= len(payload) {
+				t.Errorf("expected %s to compress %d bytes, got %d", suffix, len(payload), compressed.Len())
+			}
+
+			reader, err := NewDecompressor(compressed, suffix)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Errorf("round trip through %q changed the data", suffix)
+			}
+		})
+	}
+}
+
+func TestCompressDeterministic(t *testing.T) {
+	payload := bytes.Repeat([]byte("Package: aptforge\nVersion: 1.0\n\n"), 100)
+
+	for _, suffix := range IndexCompressionSuffixes() {
+		first, err := Compress(payload, suffix)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		second, err := Compress(payload, suffix)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("expected identical output for %q", suffix)
+		}
+	}
+
+	// The gzip header carries no modification time
+	compressed, err := Compress(payload, ".gz")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if mtime := compressed.Bytes()[4:8]; !bytes.Equal(mtime, []byte{0, 0, 0, 0}) {
+		t.Errorf("expected an empty gzip modification time, got %v", mtime)
+	}
+}
+
+func TestIndexCompressionSuffix(t *testing.T) {
+	suffix, err := IndexCompressionSuffix("zst")
+	if err != nil || suffix != ".zst" {
+		t.Errorf("expected .zst, got %q, %v", suffix, err)
+	}
+	suffix, err = IndexCompressionSuffix("none")
+	if err != nil || suffix != "" {
+		t.Errorf("expected empty suffix, got %q, %v", suffix, err)
+	}
+	if _, err := IndexCompressionSuffix("lz4"); err == nil {
+		t.Error("expected an error for lz4")
+	}
+}
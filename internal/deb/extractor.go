@@ -2,17 +2,19 @@ package deb
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"fmt"
 	"github.com/blakesmith/ar"
+	"github.com/pavliha/aptforge/internal/deb822"
 	"github.com/pavliha/aptforge/internal/filereader"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"slices"
 	"strings"
 )
 
 type Extractor interface {
-	ExtractPackageMetadata(file filereader.File) (*PackageMetadata, error)
+	Validate(file filereader.File) error
+	ExtractPackageMetadata(file io.Reader) (*PackageMetadata, error)
 }
 
 type PackageMetadata struct {
@@ -29,6 +31,35 @@ type PackageMetadata struct {
 	Suggests      string
 	Conflicts     string
 	Provides      string
+
+	// Control holds every field of the control file in its original order,
+	// including the ones above and any that have no dedicated field.
+	Control deb822.Paragraph
+
+	// Filename and Checksums describe the uploaded pool file. They are filled in
+	// by the application once the .deb has been uploaded.
+	Filename  string
+	Checksums Checksums
+}
+
+// metadataFields lists the fields that have a dedicated PackageMetadata field or
+// that describe the pool file, and are therefore not additional control fields.
+var metadataFields = []string{
+	"Package", "Version", "Architecture", "Maintainer", "Description", "Section", "Priority",
+	"Installed-Size", "Depends", "Recommends", "Suggests", "Conflicts", "Provides",
+	"Filename", "Size", "MD5sum", "SHA1", "SHA256", "SHA512",
+}
+
+// AdditionalFields returns the control fields without a dedicated PackageMetadata
+// field, such as Pre-Depends, Breaks, Multi-Arch or X-* fields, in their original order.
+func (m *PackageMetadata) AdditionalFields() []deb822.Field {
+	var fields []deb822.Field
+	for _, field := range m.Control.Fields {
+		if !slices.ContainsFunc(metadataFields, func(name string) bool { return strings.EqualFold(name, field.Name) }) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
 }
 
 // DefaultMetadataExtractor is responsible for extracting metadata from .deb files.
@@ -43,13 +74,31 @@ func New(logger *log.Entry) Extractor {
 	}
 }
 
+// Validate checks the structure of a .deb file before its metadata is trusted. See Validate.
+func (d *DefaultMetadataExtractor) Validate(file filereader.File) error {
+	d.logger.Debug("Validating .deb file structure.")
+	if err := Validate(file); err != nil {
+		d.logger.WithError(err).Error("Invalid .deb file.")
+		return err
+	}
+	return nil
+}
+
 // ExtractPackageMetadata reads metadata from a .deb file and returns it in a DebMetadata struct.
-func (d *DefaultMetadataExtractor) ExtractPackageMetadata(file filereader.File) (*PackageMetadata, error) {
+// The control member may be an uncompressed tarball or compressed with gzip, xz or zstd.
+// The file is read front to back and only seeked past members it skips when it is an
+// io.Seeker, so a stream such as a download can be passed as is.
+func (d *DefaultMetadataExtractor) ExtractPackageMetadata(file io.Reader) (*PackageMetadata, error) {
 	arReader := ar.NewReader(file)
 	d.logger.Debug("Starting extraction from .deb file.")
 
+	var members []string
 	for {
 		header, err := arReader.Next()
+		if err == io.EOF {
+			d.logger.Error("No control archive found in .deb file.")
+			return nil, fmt.Errorf("no control archive found in .deb file; members found: %s", strings.Join(members, ", "))
+		}
 		if err != nil {
 			d.logger.WithError(err).Error("Failed to read ar archive.")
 			return nil, fmt.Errorf("failed to read ar archive: %v", err)
@@ -57,61 +106,62 @@ func (d *DefaultMetadataExtractor) ExtractPackageMetadata(file filereader.File)
 
 		// Log the name of each file in the .deb archive
 		d.logger.Debugf("Found file in .deb archive: %s", header.Name)
+		members = append(members, memberName(header.Name))
 
-		if header.Name == "control.tar.gz" {
-			d.logger.Debug("Found control.tar.gz, attempting to read...")
+		compression, isControl := controlMemberCompression(header.Name)
+		if !isControl {
+			continue
+		}
 
-			gzReader, err := gzip.NewReader(arReader)
+		d.logger.Debugf("Found %s, attempting to read...", memberName(header.Name))
+		decompressor, err := NewDecompressor(arReader, compression)
+		if err != nil {
+			d.logger.WithError(err).Errorf("Failed to decompress %s.", memberName(header.Name))
+			return nil, fmt.Errorf("failed to decompress %s: %v", memberName(header.Name), err)
+		}
+		defer func(decompressor io.ReadCloser) {
+			err := decompressor.Close()
 			if err != nil {
-				d.logger.WithError(err).Error("Failed to read control.tar.gz file.")
-				return nil, fmt.Errorf("failed to read gzip file: %v", err)
+				d.logger.WithError(err).Error("Failed to close control archive.")
 			}
-			defer func(gzReader *gzip.Reader) {
-				err := gzReader.Close()
-				if err != nil {
-					d.logger.WithError(err).Error("Failed to close gzip file.")
-				}
-			}(gzReader)
-
-			// Pass the gzipped tar reader to extract control metadata
-			return d.extractControlMetadata(tar.NewReader(gzReader), d.logger)
-		}
+		}(decompressor)
+
+		// Pass the decompressed tar reader to extract control metadata
+		return d.extractControlMetadata(tar.NewReader(decompressor), d.logger)
 	}
 }
 
 func (d *DefaultMetadataExtractor) extractControlMetadata(tarReader *tar.Reader, logger *log.Entry) (*PackageMetadata, error) {
-	logger.Debug("Extracting control metadata from control.tar.gz.")
+	logger.Debug("Extracting control metadata from control archive.")
 
 	for {
 		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			logger.Error("Control archive does not contain a control file.")
+			return nil, fmt.Errorf("control archive does not contain a control file")
+		}
 		if err != nil {
 			logger.WithError(err).Error("Failed to read tar archive.")
 			return nil, fmt.Errorf("failed to read tar archive: %v", err)
 		}
 
-		// Log the name of each file in control.tar.gz
-		logger.Debugf("Found file in control.tar.gz: %s", tarHeader.Name)
+		// Log the name of each file in the control archive
+		logger.Debugf("Found file in control archive: %s", tarHeader.Name)
 
-		if tarHeader.Name == "./control" {
+		if isControlFile(tarHeader) {
 			// Log the expected file size
 			logger.Debugf("Control file expected size: %d bytes", tarHeader.Size)
 
-			controlData := make([]byte, tarHeader.Size)
-			bytesRead, err := tarReader.Read(controlData)
-
-			// Log the number of bytes successfully read
-			logger.Debugf("Successfully read %d bytes from control file", bytesRead)
-
-			// Handle EOF gracefully if the file has been fully read
-			if err != nil && err != io.EOF {
+			controlData, err := io.ReadAll(tarReader)
+			if err != nil {
 				logger.WithError(err).Error("Failed to read control file.")
 				return nil, fmt.Errorf("failed to read control file: %v", err)
 			}
 
 			// Ensure we read the expected number of bytes
-			if bytesRead != int(tarHeader.Size) {
+			if int64(len(controlData)) != tarHeader.Size {
 				logger.Error("Control file size mismatch.")
-				return nil, fmt.Errorf("control file size mismatch: expected %d bytes, got %d bytes", tarHeader.Size, bytesRead)
+				return nil, fmt.Errorf("control file size mismatch: expected %d bytes, got %d bytes", tarHeader.Size, len(controlData))
 			}
 
 			// Log the control file content for debugging
@@ -129,49 +179,43 @@ func (d *DefaultMetadataExtractor) parseControlFile(controlText string) (*Packag
 	d.logger.Debug("Parsing control file content.")
 	var metadata PackageMetadata
 
-	// Map for better handling of multiple control fields
+	// Map for better handling of multiple control fields, keyed by lowercase name since
+	// deb822 field names are case-insensitive
 	controlFields := map[string]*string{
-		"Package":        &metadata.PackageName,
-		"Version":        &metadata.Version,
-		"Architecture":   &metadata.Architecture,
-		"Maintainer":     &metadata.Maintainer,
-		"Description":    &metadata.Description,
-		"Section":        &metadata.Section,
-		"Priority":       &metadata.Priority,
-		"Installed-Size": &metadata.InstalledSize,
-		"Depends":        &metadata.Depends,
-		"Recommends":     &metadata.Recommends,
-		"Suggests":       &metadata.Suggests,
-		"Conflicts":      &metadata.Conflicts,
-		"Provides":       &metadata.Provides,
+		"package":        &metadata.PackageName,
+		"version":        &metadata.Version,
+		"architecture":   &metadata.Architecture,
+		"maintainer":     &metadata.Maintainer,
+		"description":    &metadata.Description,
+		"section":        &metadata.Section,
+		"priority":       &metadata.Priority,
+		"installed-size": &metadata.InstalledSize,
+		"depends":        &metadata.Depends,
+		"recommends":     &metadata.Recommends,
+		"suggests":       &metadata.Suggests,
+		"conflicts":      &metadata.Conflicts,
+		"provides":       &metadata.Provides,
 	}
 
-	// Split control text into lines and process each one
-	lines := strings.Split(controlText, "\n")
-	for _, line := range lines {
-		// Skip empty lines or comment lines
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Split line by first occurrence of colon (":")
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			d.logger.Warnf("Invalid control line: %s", line)
-			continue
-		}
-
-		field := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
+	paragraphs, err := deb822.ParseString(controlText)
+	if err != nil {
+		d.logger.WithError(err).Error("Failed to parse control file.")
+		return nil, fmt.Errorf("failed to parse control file: %v", err)
+	}
+	if len(paragraphs) != 1 {
+		return nil, fmt.Errorf("control file must contain exactly one paragraph, found %d", len(paragraphs))
+	}
 
+	for _, field := range paragraphs[0].Fields {
 		// Try to map the field to the corresponding metadata field
-		if target, found := controlFields[field]; found {
-			*target = value
-			d.logger.Debugf("Parsed %s: %s", field, value)
+		if target, found := controlFields[strings.ToLower(field.Name)]; found {
+			*target = field.Value
+			d.logger.Debugf("Parsed %s: %s", field.Name, field.Value)
 		} else {
-			d.logger.Warnf("Unrecognized control field: %s", field)
+			d.logger.Debugf("Keeping additional control field %s: %s", field.Name, field.Value)
 		}
 	}
+	metadata.Control = paragraphs[0]
 
 	// Ensure that required fields are present
 	if metadata.PackageName == "" || metadata.Version == "" || metadata.Architecture == "" {
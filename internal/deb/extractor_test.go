@@ -5,9 +5,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pavliha/aptforge/internal/filereader"
 	log "github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -44,6 +48,13 @@ func (m mockFileInfo) IsDir() bool        { return false }
 func (m mockFileInfo) Sys() interface{}   { return nil }
 
 func createMockDebFile(t *testing.T, controlContent string) filereader.File {
+	return createMockDebFileWithMembers(t, controlContent, "control.tar.gz", "")
+}
+
+// createMockDebFileWithMembers builds a .deb whose control tarball is stored as controlMember,
+// compressed according to its suffix. An empty controlMember omits the control tarball and
+// a non-empty dataMember adds an empty data tarball after it.
+func createMockDebFileWithMembers(t *testing.T, controlContent, controlMember, dataMember string) filereader.File {
 	// Create a buffer to hold the .deb file content
 	debBuffer := new(bytes.Buffer)
 
@@ -92,46 +103,82 @@ func createMockDebFile(t *testing.T, controlContent string) filereader.File {
 	debianBinaryContent := []byte("2.0\n")
 	writeArEntry("debian-binary", debianBinaryContent)
 
-	// Create control.tar.gz content
-	controlTarGzBuffer := new(bytes.Buffer)
-	gzipWriter := gzip.NewWriter(controlTarGzBuffer)
-	tarWriter := tar.NewWriter(gzipWriter)
+	if controlMember != "" {
+		// Write the control file into a tar archive
+		controlTarBuffer := new(bytes.Buffer)
+		tarWriter := tar.NewWriter(controlTarBuffer)
+
+		controlBytes := []byte(controlContent)
+		controlHeader := &tar.Header{
+			Name:     "./control",
+			Mode:     0644,
+			Size:     int64(len(controlBytes)),
+			Typeflag: tar.TypeReg,
+		}
+		err = tarWriter.WriteHeader(controlHeader)
+		if err != nil {
+			t.Fatalf("failed to write control tar header: %v", err)
+		}
+		_, err = tarWriter.Write(controlBytes)
+		if err != nil {
+			t.Fatalf("failed to write control tar content: %v", err)
+		}
+		err = tarWriter.Close()
+		if err != nil {
+			t.Fatalf("failed to close tar writer: %v", err)
+		}
 
-	// Write the control file into the tar archive
-	controlBytes := []byte(controlContent)
-	controlHeader := &tar.Header{
-		Name:     "./control",
-		Mode:     0644,
-		Size:     int64(len(controlBytes)),
-		Typeflag: tar.TypeReg,
+		// Write the compressed control tarball to the ar archive
+		writeArEntry(controlMember, compressForTest(t, controlTarBuffer.Bytes(), controlMember))
 	}
-	err = tarWriter.WriteHeader(controlHeader)
-	if err != nil {
-		t.Fatalf("failed to write control tar header: %v", err)
+
+	if dataMember != "" {
+		dataTarBuffer := new(bytes.Buffer)
+		err = tar.NewWriter(dataTarBuffer).Close()
+		if err != nil {
+			t.Fatalf("failed to close data tar writer: %v", err)
+		}
+		writeArEntry(dataMember, compressForTest(t, dataTarBuffer.Bytes(), dataMember))
+	}
+
+	// Return a MockFile that wraps the debBuffer
+	return &MockFile{
+		Reader: bytes.NewReader(debBuffer.Bytes()),
+	}
+}
+
+// compressForTest compresses data according to the suffix of memberName.
+func compressForTest(t *testing.T, data []byte, memberName string) []byte {
+	compressed := new(bytes.Buffer)
+
+	var writer io.WriteCloser
+	var err error
+	switch filepath.Ext(strings.TrimSuffix(memberName, "/")) {
+	case ".tar":
+		return data
+	case ".gz":
+		writer = gzip.NewWriter(compressed)
+	case ".xz":
+		writer, err = xz.NewWriter(compressed)
+	case ".zst":
+		writer, err = zstd.NewWriter(compressed)
+	default:
+		t.Fatalf("unsupported test compression for %s", memberName)
 	}
-	_, err = tarWriter.Write(controlBytes)
 	if err != nil {
-		t.Fatalf("failed to write control tar content: %v", err)
+		t.Fatalf("failed to create compressor: %v", err)
 	}
 
-	// Close tar and gzip writers
-	err = tarWriter.Close()
+	_, err = writer.Write(data)
 	if err != nil {
-		t.Fatalf("failed to close tar writer: %v", err)
+		t.Fatalf("failed to compress: %v", err)
 	}
-	err = gzipWriter.Close()
+	err = writer.Close()
 	if err != nil {
-		t.Fatalf("failed to close gzip writer: %v", err)
+		t.Fatalf("failed to close compressor: %v", err)
 	}
 
-	// Write control.tar.gz to the ar archive
-	controlTarGzBytes := controlTarGzBuffer.Bytes()
-	writeArEntry("control.tar.gz", controlTarGzBytes)
-
-	// Return a MockFile that wraps the debBuffer
-	return &MockFile{
-		Reader: bytes.NewReader(debBuffer.Bytes()),
-	}
+	return compressed.Bytes()
 }
 
 func createTestExtractor() *DefaultMetadataExtractor {
@@ -182,6 +229,139 @@ Provides: prov1`
 	// Additional field checks can be added here
 }
 
+func TestExtractMetadataMultilineDescription(t *testing.T) {
+	controlContent := `Package: testpkg
+Version: 1.0
+Architecture: amd64
+Maintainer: John Doe <johndoe@example.com>
+Description: Test package
+ This is the long description.
+ .
+ It has two paragraphs.
+`
+
+	file := createMockDebFile(t, controlContent)
+	extractor := createTestExtractor()
+
+	metadata, err := extractor.ExtractPackageMetadata(file)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expectedDescription := "Test package\nThis is the long description.\n.\nIt has two paragraphs."
+	if metadata.Description != expectedDescription {
+		t.Errorf("expected description %q, got %q", expectedDescription, metadata.Description)
+	}
+}
+
+func TestExtractMetadataAdditionalFields(t *testing.T) {
+	controlContent := `Package: libtest1
+Source: libtest
+Version: 1.0
+Architecture: arm64
+Multi-Arch: same
+Maintainer: John Doe <johndoe@example.com>
+Pre-Depends: libc6 (>= 2.34)
+Depends: dep1
+Breaks: libtest0 (<< 1.0)
+Replaces: libtest0
+Homepage: https://example.com
+X-Build-Id: 42
+Description: Test library
+`
+
+	file := createMockDebFile(t, controlContent)
+	extractor := createTestExtractor()
+
+	metadata, err := extractor.ExtractPackageMetadata(file)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(metadata.Control.Fields) != 13 {
+		t.Errorf("expected all 13 control fields to be kept, got %d", len(metadata.Control.Fields))
+	}
+	if metadata.Depends != "dep1" {
+		t.Errorf("expected depends 'dep1', got '%s'", metadata.Depends)
+	}
+
+	expectedNames := []string{"Source", "Multi-Arch", "Pre-Depends", "Breaks", "Replaces", "Homepage", "X-Build-Id"}
+	additional := metadata.AdditionalFields()
+	if len(additional) != len(expectedNames) {
+		t.Fatalf("expected %d additional fields, got %d", len(expectedNames), len(additional))
+	}
+	for i, name := range expectedNames {
+		if additional[i].Name != name {
+			t.Errorf("expected additional field %d to be %s, got %s", i, name, additional[i].Name)
+		}
+	}
+	if additional[2].Value != "libc6 (>= 2.34)" {
+		t.Errorf("expected Pre-Depends 'libc6 (>= 2.34)', got '%s'", additional[2].Value)
+	}
+}
+
+func TestExtractMetadataFieldNameCase(t *testing.T) {
+	controlContent := `package: tool
+VERSION: 1.0
+Architecture: amd64
+depends: libc6 (>= 2.34)
+Description: Test tool
+`
+
+	file := createMockDebFile(t, controlContent)
+	extractor := createTestExtractor()
+
+	metadata, err := extractor.ExtractPackageMetadata(file)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if metadata.PackageName != "tool" || metadata.Version != "1.0" {
+		t.Errorf("expected tool 1.0, got %s %s", metadata.PackageName, metadata.Version)
+	}
+	if metadata.Depends != "libc6 (>= 2.34)" {
+		t.Errorf("expected depends 'libc6 (>= 2.34)', got '%s'", metadata.Depends)
+	}
+	if additional := metadata.AdditionalFields(); len(additional) != 0 {
+		t.Errorf("expected no additional fields, got %v", additional)
+	}
+}
+
+func TestExtractMetadataControlCompressions(t *testing.T) {
+	controlContent := "Package: testpkg\nVersion: 1.0\nArchitecture: amd64\n"
+
+	// GNU ar terminates member names with a slash
+	for _, controlMember := range []string{"control.tar", "control.tar.gz", "control.tar.xz", "control.tar.zst", "control.tar.xz/"} {
+		t.Run(controlMember, func(t *testing.T) {
+			file := createMockDebFileWithMembers(t, controlContent, controlMember, "data.tar.xz")
+			extractor := createTestExtractor()
+
+			metadata, err := extractor.ExtractPackageMetadata(file)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if metadata.PackageName != "testpkg" {
+				t.Errorf("expected package name 'testpkg', got '%s'", metadata.PackageName)
+			}
+		})
+	}
+}
+
+func TestExtractMetadataNoControlArchive(t *testing.T) {
+	file := createMockDebFileWithMembers(t, "", "", "data.tar.xz")
+	extractor := createTestExtractor()
+
+	_, err := extractor.ExtractPackageMetadata(file)
+	if err == nil {
+		t.Fatal("expected error due to missing control archive, but got no error")
+	}
+
+	expectedError := "no control archive found in .deb file; members found: debian-binary, data.tar.xz"
+	if err.Error() != expectedError {
+		t.Errorf("expected error message '%s', got '%v'", expectedError, err)
+	}
+}
+
 func TestExtractMetadataIncomplete(t *testing.T) {
 	controlContent := `Package: testpkg
 Version: 1.0`
@@ -0,0 +1,97 @@
+package deb
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ArchitectureAll is the Architecture of packages that install on every architecture.
+const ArchitectureAll = "all"
+
+// dpkgCPUs lists the CPU names of dpkg's cputable.
+var dpkgCPUs = []string{
+	"i386", "ia64", "alpha", "amd64", "arc", "armeb", "arm", "arm64", "avr32", "hppa",
+	"loong64", "m32r", "m68k", "mips", "mipsel", "mipsr6", "mipsr6el", "mips64", "mips64el",
+	"mips64r6", "mips64r6el", "nios2", "or1k", "powerpc", "powerpcel", "ppc64", "ppc64el",
+	"riscv64", "s390", "s390x", "sh3", "sh3eb", "sh4", "sh4eb", "sparc", "sparc64",
+}
+
+// dpkgOSPrefixes lists the architecture prefixes of the systems in dpkg's tupletable
+// whose entries apply to every CPU. The empty prefix stands for GNU/Linux.
+var dpkgOSPrefixes = []string{
+	"", "uclibc-linux-", "musl-linux-", "kfreebsd-", "knetbsd-", "kopensolaris-", "hurd-",
+	"dragonflybsd-", "freebsd-", "openbsd-", "netbsd-", "darwin-", "aix-", "solaris-", "uclinux-",
+}
+
+// dpkgSpecialArchitectures lists the tupletable entries bound to a single ABI and CPU.
+var dpkgSpecialArchitectures = []string{
+	"armhf", "armel", "arm64ilp32", "powerpcspe", "x32",
+	"mipsn32", "mipsn32el", "mipsn32r6", "mipsn32r6el",
+	"musl-linux-armhf", "uclibc-linux-armel", "uclinux-armel", "kfreebsd-armhf", "mint-m68k",
+}
+
+// dpkgArchitectures is the set of architecture names known to dpkg.
+var dpkgArchitectures = buildDpkgArchitectures()
+
+func buildDpkgArchitectures() map[string]struct{} {
+	architectures := make(map[string]struct{})
+	for _, prefix := range dpkgOSPrefixes {
+		for _, cpu := range dpkgCPUs {
+			architectures[prefix+cpu] = struct{}{}
+		}
+	}
+	for _, architecture := range dpkgSpecialArchitectures {
+		architectures[architecture] = struct{}{}
+	}
+	return architectures
+}
+
+var (
+	// suiteNamePattern matches suite and codename names such as stable, bookworm or jammy-updates.
+	suiteNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_-]*$`)
+	// componentNamePattern matches component names such as main, nightly or non-free-firmware.
+	componentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.+_-]*$`)
+	// packageNamePattern matches Debian package names such as libc6 or g++-12.
+	packageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+)
+
+// IsKnownArchitecture reports whether architecture is in the dpkg architecture table.
+func IsKnownArchitecture(architecture string) bool {
+	_, known := dpkgArchitectures[architecture]
+	return known
+}
+
+// ValidateArchitecture checks that architecture is a binary architecture known to
+// dpkg, such as amd64, armhf or riscv64. "all" is not an architecture of its own
+// and is rejected.
+func ValidateArchitecture(architecture string) error {
+	if !IsKnownArchitecture(architecture) {
+		return fmt.Errorf("unknown architecture %q", architecture)
+	}
+	return nil
+}
+
+// ValidateSuiteName checks that name can be used as a suite or codename below dists/.
+func ValidateSuiteName(name string) error {
+	if !suiteNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid suite name %q: must start with a letter or digit and contain only letters, digits and . + _ -", name)
+	}
+	return nil
+}
+
+// ValidateComponentName checks that name can be used as a component of a suite.
+func ValidateComponentName(name string) error {
+	if !componentNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid component name %q: must start with a lowercase letter or digit and contain only lowercase letters, digits and . + _ -", name)
+	}
+	return nil
+}
+
+// ValidatePackageName checks that name is a Debian package name, which also makes it
+// safe to use as a directory and file name in the pool.
+func ValidatePackageName(name string) error {
+	if !packageNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid package name %q: must be at least two characters, start with a lowercase letter or digit and contain only lowercase letters, digits and + - .", name)
+	}
+	return nil
+}
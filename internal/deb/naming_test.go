@@ -0,0 +1,82 @@
+package deb
+
+import "testing"
+
+func TestValidateArchitecture(t *testing.T) {
+	tests := []struct {
+		architecture string
+		valid        bool
+	}{
+		{"amd64", true},
+		{"arm64", true},
+		{"armhf", true},
+		{"armel", true},
+		{"i386", true},
+		{"riscv64", true},
+		{"ppc64el", true},
+		{"s390x", true},
+		{"loong64", true},
+		{"mips64el", true},
+		{"x32", true},
+		{"musl-linux-amd64", true},
+		{"kfreebsd-amd64", true},
+		{"hurd-i386", true},
+		{"all", false},
+		{"any", false},
+		{"x86_64", false},
+		{"aarch64", false},
+		{"AMD64", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.architecture, func(t *testing.T) {
+			err := ValidateArchitecture(tt.architecture)
+			if tt.valid && err != nil {
+				t.Errorf("expected %q to be valid, got %v", tt.architecture, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected %q to be invalid", tt.architecture)
+			}
+		})
+	}
+}
+
+func TestValidateSuiteName(t *testing.T) {
+	for _, name := range []string{"stable", "bookworm", "jammy", "jammy-updates", "bookworm-backports", "Nightly", "v1.2"} {
+		if err := ValidateSuiteName(name); err != nil {
+			t.Errorf("expected suite %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-stable", ".hidden", "../stable", "bookworm/updates", "my suite"} {
+		if err := ValidateSuiteName(name); err == nil {
+			t.Errorf("expected suite %q to be invalid", name)
+		}
+	}
+}
+
+func TestValidateComponentName(t *testing.T) {
+	for _, name := range []string{"main", "contrib", "non-free", "non-free-firmware", "nightly", "restricted"} {
+		if err := ValidateComponentName(name); err != nil {
+			t.Errorf("expected component %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Main", "-main", "updates/main", "../main", "my component"} {
+		if err := ValidateComponentName(name); err == nil {
+			t.Errorf("expected component %q to be invalid", name)
+		}
+	}
+}
+
+func TestValidatePackageName(t *testing.T) {
+	for _, name := range []string{"tool", "libc6", "g++-12", "python3.11", "0ad", "xz-utils"} {
+		if err := ValidatePackageName(name); err != nil {
+			t.Errorf("expected package %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "a", "Tool", "-tool", ".tool", "my_tool", "../tool", "o/other", "my tool"} {
+		if err := ValidatePackageName(name); err == nil {
+			t.Errorf("expected package %q to be invalid", name)
+		}
+	}
+}
@@ -2,9 +2,139 @@ package deb
 
 import (
 	"fmt"
+	"github.com/pavliha/aptforge/internal/deb822"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+// packageFieldOrder is the canonical order of the fields of a Packages stanza, as
+// written by apt-ftparchive. Other fields follow in the order they were given.
+var packageFieldOrder = []string{
+	"Package", "Package-Type", "Architecture", "Subarchitecture", "Version",
+	"Revision", "Package-Revision", "Package_Revision", "Kernel-Version",
+	"Built-Using", "Static-Built-Using", "Built-For-Profiles", "Auto-Built-Package",
+	"Multi-Arch", "Status", "Priority", "Class", "Build-Essential", "Protected",
+	"Essential", "Important", "Installer-Menu-Item", "Section", "Source", "Origin",
+	"Maintainer", "Original-Maintainer", "Bugs", "Config-Version", "Conffiles",
+	"Triggers-Awaited", "Triggers-Pending", "Installed-Size", "Provides",
+	"Pre-Depends", "Depends", "Recommends", "Recommended", "Suggests", "Optional",
+	"Breaks", "Conflicts", "Replaces", "Enhances", "Filename", "MSDOS-Filename",
+	"Size", "MD5sum", "SHA1", "SHA256", "SHA512", "Homepage", "Description",
+	"Tag", "Task",
+}
+
+// PackageKey identifies a stanza in a Packages index.
+type PackageKey struct {
+	PackageName  string
+	Version      string
+	Architecture string
+}
+
+// Compare orders keys by package name, version in Debian order and architecture.
+// It returns a negative number, zero or a positive number when k sorts before,
+// equal to or after other.
+func (k PackageKey) Compare(other PackageKey) int {
+	if c := strings.Compare(k.PackageName, other.PackageName); c != 0 {
+		return c
+	}
+	if c := CompareVersions(k.Version, other.Version); c != 0 {
+		return c
+	}
+	return strings.Compare(k.Architecture, other.Architecture)
+}
+
+// String returns the key in the name_version_arch form used for pool file names.
+func (k PackageKey) String() string {
+	return k.PackageName + "_" + k.Version + "_" + k.Architecture
+}
+
+// PackageRecord is a single stanza of a Packages index.
+type PackageRecord struct {
+	Key       PackageKey
+	Paragraph deb822.Paragraph
+}
+
+// NewPackageRecord wraps a Packages stanza, checking that it can be identified.
+func NewPackageRecord(paragraph deb822.Paragraph) (PackageRecord, error) {
+	key := PackageKey{
+		PackageName:  paragraph.Get("Package"),
+		Version:      paragraph.Get("Version"),
+		Architecture: paragraph.Get("Architecture"),
+	}
+	if key.PackageName == "" || key.Version == "" || key.Architecture == "" {
+		return PackageRecord{}, fmt.Errorf("missing Package, Version or Architecture field")
+	}
+
+	return PackageRecord{Key: key, Paragraph: paragraph}, nil
+}
+
+// SHA256 returns the checksum of the pool file the stanza refers to.
+func (r *PackageRecord) SHA256() string {
+	return r.Paragraph.Get("SHA256")
+}
+
+// ParsePackagesFile splits the contents of a Packages index into its stanzas.
+func ParsePackagesFile(contents string) ([]PackageRecord, error) {
+	paragraphs, err := deb822.ParseString(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]PackageRecord, 0, len(paragraphs))
+	for i, paragraph := range paragraphs {
+		record, err := NewPackageRecord(paragraph)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stanza %d: %w", i+1, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// CreatePackagesFile joins records into the contents of a Packages index. Stanzas
+// are sorted by their keys and their fields put in canonical order, so the same
+// packages always give the same index.
+func CreatePackagesFile(records []PackageRecord) string {
+	records = slices.Clone(records)
+	SortPackageRecords(records)
+
+	paragraphs := make([]deb822.Paragraph, 0, len(records))
+	for _, record := range records {
+		paragraphs = append(paragraphs, canonicalPackageParagraph(record.Paragraph))
+	}
+	return deb822.Format(paragraphs)
+}
+
+// SortPackageRecords sorts records by package name, version and architecture.
+func SortPackageRecords(records []PackageRecord) {
+	slices.SortStableFunc(records, func(a, b PackageRecord) int {
+		return a.Key.Compare(b.Key)
+	})
+}
+
+// canonicalPackageParagraph returns a copy of paragraph with its fields in the
+// canonical order of a Packages stanza.
+func canonicalPackageParagraph(paragraph deb822.Paragraph) deb822.Paragraph {
+	fields := slices.Clone(paragraph.Fields)
+	slices.SortStableFunc(fields, func(a, b deb822.Field) int {
+		return packageFieldRank(a.Name) - packageFieldRank(b.Name)
+	})
+	return deb822.Paragraph{Fields: fields}
+}
+
+// packageFieldRank returns the position of a field in packageFieldOrder, or the
+// length of the list for other fields.
+func packageFieldRank(name string) int {
+	for i, field := range packageFieldOrder {
+		if strings.EqualFold(field, name) {
+			return i
+		}
+	}
+	return len(packageFieldOrder)
+}
+
 type PackagesContent struct {
 	PackageName   string
 	Version       string
@@ -19,45 +149,62 @@ type PackagesContent struct {
 	Suggests      string
 	Conflicts     string
 	Provides      string
+	// AdditionalFields holds the remaining control fields, written in the given order.
+	AdditionalFields []deb822.Field
+	Filename         string
+	Size             int64
+	MD5sum           string
+	SHA1             string
+	SHA256           string
 }
 
-// CreatePackagesFileContents generates a formatted control file section for a .deb package.
-func CreatePackagesFileContents(contents *PackagesContent) string {
-	var sb strings.Builder
+// CreatePackagesParagraph generates the Packages stanza for a .deb package.
+func CreatePackagesParagraph(contents *PackagesContent) deb822.Paragraph {
+	var paragraph deb822.Paragraph
 
 	// Start with required fields
-	sb.WriteString(fmt.Sprintf("Package: %s\n", contents.PackageName))
-	sb.WriteString(fmt.Sprintf("Version: %s\n", contents.Version))
-	sb.WriteString(fmt.Sprintf("Architecture: %s\n", contents.Architecture))
-	sb.WriteString(fmt.Sprintf("Maintainer: %s\n", contents.Maintainer))
-	sb.WriteString(fmt.Sprintf("Description: %s\n", contents.Description))
+	paragraph.Set("Package", contents.PackageName)
+	paragraph.Set("Version", contents.Version)
+	paragraph.Set("Architecture", contents.Architecture)
+	paragraph.Set("Maintainer", contents.Maintainer)
+	paragraph.Set("Description", contents.Description)
 
 	// Add optional fields if present
-	if contents.Section != "" {
-		sb.WriteString(fmt.Sprintf("Section: %s\n", contents.Section))
-	}
-	if contents.Priority != "" {
-		sb.WriteString(fmt.Sprintf("Priority: %s\n", contents.Priority))
-	}
-	if contents.InstalledSize != "" {
-		sb.WriteString(fmt.Sprintf("Installed-Size: %s\n", contents.InstalledSize))
-	}
-	if contents.Depends != "" {
-		sb.WriteString(fmt.Sprintf("Depends: %s\n", contents.Depends))
-	}
-	if contents.Recommends != "" {
-		sb.WriteString(fmt.Sprintf("Recommends: %s\n", contents.Recommends))
-	}
-	if contents.Suggests != "" {
-		sb.WriteString(fmt.Sprintf("Suggests: %s\n", contents.Suggests))
-	}
-	if contents.Conflicts != "" {
-		sb.WriteString(fmt.Sprintf("Conflicts: %s\n", contents.Conflicts))
+	setIfPresent(&paragraph, "Section", contents.Section)
+	setIfPresent(&paragraph, "Priority", contents.Priority)
+	setIfPresent(&paragraph, "Installed-Size", contents.InstalledSize)
+	setIfPresent(&paragraph, "Depends", contents.Depends)
+	setIfPresent(&paragraph, "Recommends", contents.Recommends)
+	setIfPresent(&paragraph, "Suggests", contents.Suggests)
+	setIfPresent(&paragraph, "Conflicts", contents.Conflicts)
+	setIfPresent(&paragraph, "Provides", contents.Provides)
+
+	// Keep every other control field so dependency resolution sees the whole package
+	for _, field := range contents.AdditionalFields {
+		paragraph.Set(field.Name, field.Value)
 	}
-	if contents.Provides != "" {
-		sb.WriteString(fmt.Sprintf("Provides: %s\n", contents.Provides))
+
+	// Add the pool location and checksums apt needs to download and verify the package
+	setIfPresent(&paragraph, "Filename", contents.Filename)
+	if contents.Size > 0 {
+		paragraph.Set("Size", strconv.FormatInt(contents.Size, 10))
 	}
+	setIfPresent(&paragraph, "MD5sum", contents.MD5sum)
+	setIfPresent(&paragraph, "SHA1", contents.SHA1)
+	setIfPresent(&paragraph, "SHA256", contents.SHA256)
 
-	// Return the full package contents as a string
-	return sb.String()
+	return canonicalPackageParagraph(paragraph)
+}
+
+// CreatePackagesFileContents generates a formatted control file section for a .deb package.
+func CreatePackagesFileContents(contents *PackagesContent) string {
+	paragraph := CreatePackagesParagraph(contents)
+	return paragraph.String()
+}
+
+// setIfPresent sets the field only when value is not empty
+func setIfPresent(paragraph *deb822.Paragraph, name, value string) {
+	if value != "" {
+		paragraph.Set(name, value)
+	}
 }
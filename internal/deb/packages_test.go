@@ -1,6 +1,8 @@
 package deb
 
 import (
+	"github.com/pavliha/aptforge/internal/deb822"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -25,18 +27,112 @@ func TestCreatePackagesFileContents(t *testing.T) {
 		}
 
 		expected := `Package: testpkg
-Version: 1.0
 Architecture: amd64
-Maintainer: John Doe <johndoe@example.com>
-Description: Test package
-Section: utils
+Version: 1.0
 Priority: optional
+Section: utils
+Maintainer: John Doe <johndoe@example.com>
 Installed-Size: 2048
+Provides: prov1
 Depends: dep1, dep2
 Recommends: rec1
 Suggests: sug1
 Conflicts: conf1
-Provides: prov1
+Description: Test package
+`
+
+		result := CreatePackagesFileContents(contents)
+		if result != expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+		}
+	})
+
+	// Test case with the pool location and checksums
+	t.Run("pool file fields", func(t *testing.T) {
+		contents := &PackagesContent{
+			PackageName:  "testpkg",
+			Version:      "1.0",
+			Architecture: "amd64",
+			Maintainer:   "John Doe <johndoe@example.com>",
+			Description:  "Test package",
+			Filename:     "pool/main/t/testpkg/testpkg_1.0_amd64.deb",
+			Size:         1234,
+			MD5sum:       "md5",
+			SHA1:         "sha1",
+			SHA256:       "sha256",
+		}
+
+		expected := `Package: testpkg
+Architecture: amd64
+Version: 1.0
+Maintainer: John Doe <johndoe@example.com>
+Filename: pool/main/t/testpkg/testpkg_1.0_amd64.deb
+Size: 1234
+MD5sum: md5
+SHA1: sha1
+SHA256: sha256
+Description: Test package
+`
+
+		result := CreatePackagesFileContents(contents)
+		if result != expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+		}
+	})
+
+	// Test case with a multi-line description
+	t.Run("multi-line description", func(t *testing.T) {
+		contents := &PackagesContent{
+			PackageName:  "testpkg",
+			Version:      "1.0",
+			Architecture: "amd64",
+			Maintainer:   "John Doe <johndoe@example.com>",
+			Description:  "Test package\nLong description.\n.\nSecond paragraph.",
+		}
+
+		expected := `Package: testpkg
+Architecture: amd64
+Version: 1.0
+Maintainer: John Doe <johndoe@example.com>
+Description: Test package
+ Long description.
+ .
+ Second paragraph.
+`
+
+		result := CreatePackagesFileContents(contents)
+		if result != expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+		}
+	})
+
+	// Test case with additional control fields
+	t.Run("additional fields", func(t *testing.T) {
+		contents := &PackagesContent{
+			PackageName:  "libtest1",
+			Version:      "1.0",
+			Architecture: "arm64",
+			Maintainer:   "John Doe <johndoe@example.com>",
+			Description:  "Test library",
+			Depends:      "dep1",
+			AdditionalFields: []deb822.Field{
+				{Name: "Multi-Arch", Value: "same"},
+				{Name: "Pre-Depends", Value: "libc6 (>= 2.34)"},
+				{Name: "X-Build-Id", Value: "42"},
+			},
+			Filename: "pool/main/l/libtest1/libtest1_1.0_arm64.deb",
+		}
+
+		expected := `Package: libtest1
+Architecture: arm64
+Version: 1.0
+Multi-Arch: same
+Maintainer: John Doe <johndoe@example.com>
+Pre-Depends: libc6 (>= 2.34)
+Depends: dep1
+Filename: pool/main/l/libtest1/libtest1_1.0_arm64.deb
+Description: Test library
+X-Build-Id: 42
 `
 
 		result := CreatePackagesFileContents(contents)
@@ -56,8 +152,8 @@ Provides: prov1
 		}
 
 		expected := `Package: testpkg
-Version: 1.0
 Architecture: amd64
+Version: 1.0
 Maintainer: John Doe <johndoe@example.com>
 Description: Test package
 `
@@ -81,12 +177,12 @@ Description: Test package
 		}
 
 		expected := `Package: testpkg
-Version: 1.0
 Architecture: amd64
-Maintainer: John Doe <johndoe@example.com>
-Description: Test package
+Version: 1.0
 Section: utils
+Maintainer: John Doe <johndoe@example.com>
 Installed-Size: 2048
+Description: Test package
 `
 
 		result := CreatePackagesFileContents(contents)
@@ -114,3 +210,144 @@ Installed-Size: 2048
 		}
 	})
 }
+
+func TestParsePackagesFile(t *testing.T) {
+	contents := `Package: first
+Architecture: amd64
+Version: 1.0
+SHA256: abc
+Description: First package
+ with a long description
+
+Package: second
+Architecture: all
+Version: 2.0
+`
+
+	records, err := ParsePackagesFile(contents)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	expectedKey := PackageKey{PackageName: "first", Version: "1.0", Architecture: "amd64"}
+	if records[0].Key != expectedKey {
+		t.Errorf("expected key %v, got %v", expectedKey, records[0].Key)
+	}
+	if records[0].SHA256() != "abc" {
+		t.Errorf("expected SHA256 'abc', got '%s'", records[0].SHA256())
+	}
+	if records[0].Paragraph.Get("Description") != "First package\nwith a long description" {
+		t.Errorf("expected multi-line description, got '%s'", records[0].Paragraph.Get("Description"))
+	}
+	if records[1].Key.Architecture != "all" {
+		t.Errorf("expected architecture 'all', got '%s'", records[1].Key.Architecture)
+	}
+
+	// Joining the records again must reproduce the original, canonical index
+	if result := CreatePackagesFile(records); result != contents {
+		t.Errorf("expected:\n%s\ngot:\n%s", contents, result)
+	}
+}
+
+func TestParsePackagesFileEmpty(t *testing.T) {
+	records, err := ParsePackagesFile("")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records, got %d", len(records))
+	}
+}
+
+func TestParsePackagesFileInvalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		contents      string
+		expectedError string
+	}{
+		{
+			name:          "missing version",
+			contents:      "Package: first\nArchitecture: amd64\n",
+			expectedError: "missing Package, Version or Architecture field",
+		},
+		{
+			name:          "line without colon",
+			contents:      "Package: first\nnot a field\n",
+			expectedError: "invalid field: not a field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePackagesFile(tt.contents)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected error containing '%s', got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestCreatePackagesFileDeterministic(t *testing.T) {
+	contents := `Package: tool
+Version: 1.10
+Description: Tool
+Architecture: arm64
+X-Build-Id: 7
+
+Package: tool
+Version: 1.9
+Architecture: amd64
+
+Package: lib
+Version: 2:0.1
+Architecture: amd64
+
+Package: tool
+Version: 1.10~rc1
+Architecture: amd64
+
+Package: tool
+Version: 1.10
+Architecture: amd64
+`
+
+	expected := `Package: lib
+Architecture: amd64
+Version: 2:0.1
+
+Package: tool
+Architecture: amd64
+Version: 1.9
+
+Package: tool
+Architecture: amd64
+Version: 1.10~rc1
+
+Package: tool
+Architecture: amd64
+Version: 1.10
+
+Package: tool
+Architecture: arm64
+Version: 1.10
+Description: Tool
+X-Build-Id: 7
+`
+
+	records, err := ParsePackagesFile(contents)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result := CreatePackagesFile(records); result != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+	}
+
+	// The order the stanzas were given in does not matter
+	slices.Reverse(records)
+	if result := CreatePackagesFile(records); result != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+	}
+}
@@ -2,6 +2,8 @@ package deb
 
 import (
 	"fmt"
+	"github.com/pavliha/aptforge/internal/deb822"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,52 +21,106 @@ type ReleaseFileContent struct {
 	Archive      string
 	Component    string
 	Architecture string
+	MD5Sum       []ChecksumInfo
+	SHA1         []ChecksumInfo
 	SHA256       []ChecksumInfo
+	SHA512       []ChecksumInfo
+	// AcquireByHash advertises that the indices are also available below by-hash/
+	AcquireByHash bool
+	// Date is written to the Date field; the current time is used when it is zero
+	Date time.Time
+}
+
+// AddChecksums records filename with its size and digests in every checksum section.
+func (c *ReleaseFileContent) AddChecksums(filename string, checksums Checksums) {
+	c.MD5Sum = append(c.MD5Sum, ChecksumInfo{Checksum: checksums.MD5, Size: checksums.Size, Filename: filename})
+	c.SHA1 = append(c.SHA1, ChecksumInfo{Checksum: checksums.SHA1, Size: checksums.Size, Filename: filename})
+	c.SHA256 = append(c.SHA256, ChecksumInfo{Checksum: checksums.SHA256, Size: checksums.Size, Filename: filename})
+	c.SHA512 = append(c.SHA512, ChecksumInfo{Checksum: checksums.SHA512, Size: checksums.Size, Filename: filename})
 }
 
 // CreatePackageReleaseFileContents generates the content of a Release file
 func CreatePackageReleaseFileContents(content ReleaseFileContent) string {
-	var sb strings.Builder
+	var paragraph deb822.Paragraph
 
-	sb.WriteString(fmt.Sprintf("Origin: %s\n", content.Origin))
-	sb.WriteString(fmt.Sprintf("Label: %s\n", content.Label))
-	sb.WriteString(fmt.Sprintf("Suite: %s\n", content.Archive))
-	sb.WriteString(fmt.Sprintf("Component: %s\n", content.Component))
-	sb.WriteString(fmt.Sprintf("Architecture: %s\n", content.Architecture))
-	sb.WriteString(fmt.Sprintf("Date: %s\n", generateCurrentDate())) // You can format the date as needed
-	sb.WriteString(fmt.Sprintf("MD5Sum:\n"))
-	sb.WriteString(fmt.Sprintf("SHA256:\n"))
-
-	// Add SHA256 checksums
-	for _, checksum := range content.SHA256 {
-		sb.WriteString(fmt.Sprintf(" %s %d %s\n", checksum.Checksum, checksum.Size, checksum.Filename))
-	}
+	paragraph.Set("Origin", content.Origin)
+	paragraph.Set("Label", content.Label)
+	paragraph.Set("Suite", content.Archive)
+	paragraph.Set("Component", content.Component)
+	paragraph.Set("Architecture", content.Architecture)
+	paragraph.Set("Date", formatReleaseDate(content.Date))
+	paragraph.Set("MD5Sum", checksumValue(content.MD5Sum))
+	paragraph.Set("SHA1", checksumValue(content.SHA1))
+	paragraph.Set("SHA256", checksumValue(content.SHA256))
+	paragraph.Set("SHA512", checksumValue(content.SHA512))
 
-	return sb.String()
+	return paragraph.String()
 }
 
 // CreateSuiteReleaseFileContents generates the content of a Release file
 func CreateSuiteReleaseFileContents(content ReleaseFileContent) string {
-	var sb strings.Builder
+	var paragraph deb822.Paragraph
 
-	sb.WriteString(fmt.Sprintf("Origin: %s\n", content.Origin))
-	sb.WriteString(fmt.Sprintf("Label: %s\n", content.Label))
-	sb.WriteString(fmt.Sprintf("Suite: %s\n", content.Archive))
-	sb.WriteString(fmt.Sprintf("Codename: %s\n", content.Archive)) // Codename matches Suite
-	sb.WriteString(fmt.Sprintf("Architectures: %s\n", content.Architecture))
-	sb.WriteString(fmt.Sprintf("Components: %s\n", content.Component))
-	sb.WriteString(fmt.Sprintf("Date: %s\n", generateCurrentDate())) // Custom date format
-	sb.WriteString(fmt.Sprintf("SHA256:\n"))
-
-	// Add SHA256 checksums
-	for _, checksum := range content.SHA256 {
-		sb.WriteString(fmt.Sprintf(" %s %d %s\n", checksum.Checksum, checksum.Size, checksum.Filename))
+	paragraph.Set("Origin", content.Origin)
+	paragraph.Set("Label", content.Label)
+	paragraph.Set("Suite", content.Archive)
+	paragraph.Set("Codename", content.Archive) // Codename matches Suite
+	paragraph.Set("Architectures", content.Architecture)
+	paragraph.Set("Components", content.Component)
+	paragraph.Set("Date", formatReleaseDate(content.Date))
+	if content.AcquireByHash {
+		paragraph.Set("Acquire-By-Hash", "yes")
 	}
 
+	// Add every checksum section so apt can verify the indices with any hash it trusts
+	paragraph.Set("MD5Sum", checksumValue(content.MD5Sum))
+	paragraph.Set("SHA1", checksumValue(content.SHA1))
+	paragraph.Set("SHA256", checksumValue(content.SHA256))
+	paragraph.Set("SHA512", checksumValue(content.SHA512))
+
+	return paragraph.String()
+}
+
+// checksumValue formats a checksum list as a multi-line field value with one file per line
+func checksumValue(checksums []ChecksumInfo) string {
+	var sb strings.Builder
+	for _, checksum := range checksums {
+		sb.WriteString(fmt.Sprintf("\n%s %d %s", checksum.Checksum, checksum.Size, checksum.Filename))
+	}
 	return sb.String()
 }
 
-// generateCurrentDate returns the current date in the proper format
-func generateCurrentDate() string {
-	return time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 MST")
+// ParseChecksums parses the value of a Release checksum field such as SHA256 back
+// into its "<checksum> <size> <filename>" entries.
+func ParseChecksums(value string) ([]ChecksumInfo, error) {
+	var checksums []ChecksumInfo
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid checksum line: %q", line)
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in checksum line %q: %v", line, err)
+		}
+		checksums = append(checksums, ChecksumInfo{Checksum: parts[0], Size: size, Filename: parts[2]})
+	}
+	return checksums, nil
+}
+
+// releaseDateLayout is the RFC 2822 layout of the Date field of Release files.
+const releaseDateLayout = "Mon, 02 Jan 2006 15:04:05 MST"
+
+// formatReleaseDate formats date in UTC for the Date field, using the current time
+// when date is zero.
+func formatReleaseDate(date time.Time) string {
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return date.UTC().Format(releaseDateLayout)
 }
@@ -3,8 +3,13 @@ package deb
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
+// testReleaseDate is the Date of the Release files built by the tests, so that their
+// contents do not depend on when the tests run.
+var testReleaseDate = time.Unix(1700000000, 0)
+
 // TestCreatePackageReleaseFileContents tests the architecture-specific release file generation.
 func TestCreatePackageReleaseFileContents(t *testing.T) {
 	// Test case with typical inputs
@@ -15,6 +20,7 @@ func TestCreatePackageReleaseFileContents(t *testing.T) {
 			Label:        "Debian",
 			Archive:      "stable",
 			Architecture: "amd64",
+			Date:         testReleaseDate,
 			SHA256: []ChecksumInfo{
 				{
 					Checksum: "123abc",
@@ -29,10 +35,12 @@ Label: Debian
 Suite: stable
 Component: main
 Architecture: amd64
-Date: ` + generateCurrentDate() + `
+Date: Tue, 14 Nov 2023 22:13:20 UTC
 MD5Sum:
+SHA1:
 SHA256:
  123abc 1024 package1.deb
+SHA512:
 `
 
 		result := CreatePackageReleaseFileContents(content)
@@ -49,6 +57,7 @@ SHA256:
 			Label:        "Ubuntu",
 			Archive:      "testing",
 			Architecture: "arm64",
+			Date:         testReleaseDate,
 			SHA256:       []ChecksumInfo{},
 		}
 
@@ -57,9 +66,11 @@ Label: Ubuntu
 Suite: testing
 Component: main
 Architecture: arm64
-Date: ` + generateCurrentDate() + `
+Date: Tue, 14 Nov 2023 22:13:20 UTC
 MD5Sum:
+SHA1:
 SHA256:
+SHA512:
 `
 
 		result := CreatePackageReleaseFileContents(content)
@@ -76,6 +87,7 @@ SHA256:
 			Label:        "Canonical",
 			Archive:      "unstable",
 			Architecture: "i386",
+			Date:         testReleaseDate,
 			SHA256: []ChecksumInfo{
 				{
 					Checksum: "abc123",
@@ -95,11 +107,13 @@ Label: Canonical
 Suite: unstable
 Component: contrib
 Architecture: i386
-Date: ` + generateCurrentDate() + `
+Date: Tue, 14 Nov 2023 22:13:20 UTC
 MD5Sum:
+SHA1:
 SHA256:
  abc123 2048 package2.deb
  def456 4096 package3.deb
+SHA512:
 `
 
 		result := CreatePackageReleaseFileContents(content)
@@ -107,6 +121,34 @@ SHA256:
 			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
 		}
 	})
+
+	// Test case listing a Packages variant in every checksum section
+	t.Run("all checksum sections", func(t *testing.T) {
+		content := ReleaseFileContent{
+			Component:    "main",
+			Archive:      "stable",
+			Architecture: "amd64",
+			Date:         testReleaseDate,
+		}
+		data := []byte("Package: tool\n")
+		checksums := ComputeChecksums(data)
+		content.AddChecksums("Packages.xz", checksums)
+
+		expected := `MD5Sum:
+ ` + checksums.MD5 + ` 14 Packages.xz
+SHA1:
+ ` + checksums.SHA1 + ` 14 Packages.xz
+SHA256:
+ ` + checksums.SHA256 + ` 14 Packages.xz
+SHA512:
+ ` + checksums.SHA512 + ` 14 Packages.xz
+`
+
+		result := CreatePackageReleaseFileContents(content)
+		if !strings.HasSuffix(result, expected) {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+		}
+	})
 }
 
 // TestCreateSuiteReleaseFileContents tests the high-level suite release file generation.
@@ -119,6 +161,7 @@ func TestCreateSuiteReleaseFileContents(t *testing.T) {
 			Label:        "Debian",
 			Archive:      "stable",
 			Architecture: "amd64",
+			Date:         testReleaseDate,
 			SHA256: []ChecksumInfo{
 				{
 					Checksum: "123abc",
@@ -134,9 +177,12 @@ Suite: stable
 Codename: stable
 Architectures: amd64
 Components: main
-Date: ` + generateCurrentDate() + `
+Date: Tue, 14 Nov 2023 22:13:20 UTC
+MD5Sum:
+SHA1:
 SHA256:
  123abc 1024 package1.deb
+SHA512:
 `
 
 		result := CreateSuiteReleaseFileContents(content)
@@ -153,6 +199,7 @@ SHA256:
 			Label:        "Ubuntu",
 			Archive:      "testing",
 			Architecture: "arm64",
+			Date:         testReleaseDate,
 			SHA256:       []ChecksumInfo{},
 		}
 
@@ -162,8 +209,11 @@ Suite: testing
 Codename: testing
 Architectures: arm64
 Components: main
-Date: ` + generateCurrentDate() + `
+Date: Tue, 14 Nov 2023 22:13:20 UTC
+MD5Sum:
+SHA1:
 SHA256:
+SHA512:
 `
 
 		result := CreateSuiteReleaseFileContents(content)
@@ -180,6 +230,7 @@ SHA256:
 			Label:        "Canonical",
 			Archive:      "unstable",
 			Architecture: "i386",
+			Date:         testReleaseDate,
 			SHA256: []ChecksumInfo{
 				{
 					Checksum: "abc123",
@@ -200,10 +251,37 @@ Suite: unstable
 Codename: unstable
 Architectures: i386
 Components: contrib
-Date: ` + generateCurrentDate() + `
+Date: Tue, 14 Nov 2023 22:13:20 UTC
+MD5Sum:
+SHA1:
 SHA256:
  abc123 2048 package2.deb
  def456 4096 package3.deb
+SHA512:
+`
+
+		result := CreateSuiteReleaseFileContents(content)
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+		}
+	})
+
+	// Test case advertising by-hash indices
+	t.Run("suite acquire by hash", func(t *testing.T) {
+		content := ReleaseFileContent{
+			Component:     "main",
+			Origin:        "Debian",
+			Label:         "Debian",
+			Archive:       "stable",
+			Architecture:  "amd64",
+			Date:          testReleaseDate,
+			AcquireByHash: true,
+		}
+
+		expected := `Components: main
+Date: Tue, 14 Nov 2023 22:13:20 UTC
+Acquire-By-Hash: yes
+MD5Sum:
 `
 
 		result := CreateSuiteReleaseFileContents(content)
@@ -211,4 +289,75 @@ SHA256:
 			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
 		}
 	})
+
+	// Test case with a fixed date for reproducible output
+	t.Run("suite fixed date", func(t *testing.T) {
+		content := ReleaseFileContent{
+			Component:    "main",
+			Archive:      "stable",
+			Architecture: "amd64",
+			Date:         testReleaseDate.In(time.FixedZone("CET", 3600)),
+		}
+
+		expected := "Date: Tue, 14 Nov 2023 22:13:20 UTC\n"
+
+		result := CreateSuiteReleaseFileContents(content)
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+		}
+		if result != CreateSuiteReleaseFileContents(content) {
+			t.Errorf("expected identical Release files for the same content")
+		}
+	})
+}
+
+// TestAddChecksums tests that every checksum section receives the file.
+func TestAddChecksums(t *testing.T) {
+	var content ReleaseFileContent
+	checksums := ComputeChecksums([]byte("hello\n"))
+	content.AddChecksums("main/binary-amd64/Packages", checksums)
+	content.Origin = "Debian"
+	content.Label = "Debian"
+	content.Archive = "stable"
+	content.Architecture = "amd64"
+	content.Component = "main"
+
+	expected := `MD5Sum:
+ ` + checksums.MD5 + ` 6 main/binary-amd64/Packages
+SHA1:
+ ` + checksums.SHA1 + ` 6 main/binary-amd64/Packages
+SHA256:
+ ` + checksums.SHA256 + ` 6 main/binary-amd64/Packages
+SHA512:
+ ` + checksums.SHA512 + ` 6 main/binary-amd64/Packages
+`
+
+	result := CreateSuiteReleaseFileContents(content)
+	if !strings.HasSuffix(result, expected) {
+		t.Errorf("expected suffix:\n%s\ngot:\n%s", expected, result)
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	content := ReleaseFileContent{}
+	content.AddChecksums("main/binary-amd64/Packages", Checksums{Size: 1024, SHA256: "123abc"})
+	content.AddChecksums("main/binary-amd64/Packages.gz", Checksums{Size: 512, SHA256: "456def"})
+
+	checksums, err := ParseChecksums(checksumValue(content.SHA256))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(checksums) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(checksums))
+	}
+	if checksums[1] != (ChecksumInfo{Checksum: "456def", Size: 512, Filename: "main/binary-amd64/Packages.gz"}) {
+		t.Errorf("unexpected entry: %+v", checksums[1])
+	}
+
+	if _, err := ParseChecksums("\n123abc notasize Packages"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+	if _, err := ParseChecksums("\n123abc 1024"); err == nil {
+		t.Error("expected an error for a missing filename")
+	}
 }
@@ -0,0 +1,199 @@
+package deb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/blakesmith/ar"
+	"github.com/pavliha/aptforge/internal/filereader"
+	"io"
+	"strings"
+)
+
+// ErrInvalidPackage is returned by Validate for archives dpkg would refuse to install.
+var ErrInvalidPackage = errors.New("invalid .deb package")
+
+// arMagic is the global header every ar archive starts with.
+const arMagic = "!<arch>\n"
+
+// Validate checks the ar structure of a .deb before it is published: debian-binary
+// must come first and declare format 2.x, a control tarball holding a control file
+// must follow, then a data tarball. Every member must be complete. Members whose
+// name starts with an underscore are reserved for extensions and are skipped.
+// The file is rewound afterwards.
+func Validate(file filereader.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file: %v", err)
+	}
+	if err := ValidateReader(file); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file: %v", err)
+	}
+	return nil
+}
+
+// ValidateReader checks the .deb read from reader like Validate. It reads front to
+// back up to the end of the data tarball, so a stream such as a download can be
+// checked as it arrives; the rest of the stream is left unread.
+func ValidateReader(reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(len(arMagic))
+	if err != nil || string(magic) != arMagic {
+		return fmt.Errorf("%w: not an ar archive", ErrInvalidPackage)
+	}
+
+	arReader := ar.NewReader(buffered)
+	if err := validateDebianBinary(arReader); err != nil {
+		return err
+	}
+
+	var controlFound, dataFound bool
+	for !dataFound {
+		header, err := arReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: truncated ar member header: %v", ErrInvalidPackage, err)
+		}
+		name := memberName(header.Name)
+
+		switch {
+		case strings.HasPrefix(name, "_"):
+			read, copyErr := io.Copy(io.Discard, arReader)
+			err = validateMemberSize(name, header.Size, read, copyErr)
+		case !controlFound:
+			compression, isControl := controlMemberCompression(name)
+			if !isControl {
+				return fmt.Errorf("%w: expected control archive after debian-binary, found %s", ErrInvalidPackage, name)
+			}
+			err = validateControlMember(arReader, name, header.Size, compression)
+			controlFound = true
+		default:
+			compression, isData := dataMemberCompression(name)
+			if !isData {
+				return fmt.Errorf("%w: expected data archive after control archive, found %s", ErrInvalidPackage, name)
+			}
+			err = validateDataMember(arReader, name, header.Size, compression)
+			dataFound = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !controlFound {
+		return fmt.Errorf("%w: missing control archive", ErrInvalidPackage)
+	}
+	if !dataFound {
+		return fmt.Errorf("%w: missing data archive", ErrInvalidPackage)
+	}
+	return nil
+}
+
+// validateDebianBinary checks that the first member is debian-binary with format version 2.x.
+func validateDebianBinary(arReader *ar.Reader) error {
+	header, err := arReader.Next()
+	if err != nil {
+		return fmt.Errorf("%w: missing debian-binary member: %v", ErrInvalidPackage, err)
+	}
+	if memberName(header.Name) != "debian-binary" {
+		return fmt.Errorf("%w: first member is %s, expected debian-binary", ErrInvalidPackage, memberName(header.Name))
+	}
+
+	var content bytes.Buffer
+	read, err := io.Copy(&content, arReader)
+	if err := validateMemberSize("debian-binary", header.Size, read, err); err != nil {
+		return err
+	}
+
+	version, _, _ := strings.Cut(content.String(), "\n")
+	if !strings.HasPrefix(version, "2.") {
+		return fmt.Errorf("%w: unsupported format version %q in debian-binary, expected 2.0", ErrInvalidPackage, version)
+	}
+	return nil
+}
+
+// validateControlMember checks that the control tarball decompresses and holds a control file.
+func validateControlMember(arReader *ar.Reader, name string, size int64, compression string) error {
+	counter := &countingReader{reader: arReader}
+	decompressor, err := NewDecompressor(counter, compression)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decompress %s: %v", ErrInvalidPackage, name, err)
+	}
+	defer decompressor.Close()
+
+	tarReader := tar.NewReader(decompressor)
+	controlFound := false
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidPackage, name, err)
+		}
+		if isControlFile(tarHeader) {
+			controlFound = true
+		}
+		if _, err := io.Copy(io.Discard, tarReader); err != nil {
+			return fmt.Errorf("%w: failed to read %s from %s: %v", ErrInvalidPackage, tarHeader.Name, name, err)
+		}
+	}
+	if !controlFound {
+		return fmt.Errorf("%w: %s does not contain a control file", ErrInvalidPackage, name)
+	}
+
+	_, err = io.Copy(io.Discard, counter)
+	return validateMemberSize(name, size, counter.count, err)
+}
+
+// validateDataMember checks that the data tarball is complete and starts with a readable tar header.
+func validateDataMember(arReader *ar.Reader, name string, size int64, compression string) error {
+	counter := &countingReader{reader: arReader}
+	decompressor, err := NewDecompressor(counter, compression)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decompress %s: %v", ErrInvalidPackage, name, err)
+	}
+	defer decompressor.Close()
+
+	if _, err := tar.NewReader(decompressor).Next(); err != nil && err != io.EOF {
+		return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidPackage, name, err)
+	}
+
+	_, err = io.Copy(io.Discard, counter)
+	return validateMemberSize(name, size, counter.count, err)
+}
+
+// validateMemberSize checks that a member was read completely.
+func validateMemberSize(name string, size, read int64, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidPackage, name, err)
+	}
+	if read != size {
+		return fmt.Errorf("%w: %s is truncated: expected %d bytes, got %d bytes", ErrInvalidPackage, name, size, read)
+	}
+	return nil
+}
+
+// isControlFile reports whether a control tarball entry is the control file,
+// which may be stored with or without the "./" prefix.
+func isControlFile(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg && strings.TrimPrefix(header.Name, "./") == "control"
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
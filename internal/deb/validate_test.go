@@ -0,0 +1,209 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type arMember struct {
+	name    string
+	content []byte
+}
+
+// buildArchive writes members into an ar archive.
+func buildArchive(t *testing.T, members ...arMember) []byte {
+	archive := bytes.NewBufferString("!<arch>\n")
+	for _, member := range members {
+		header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", member.name, 0, 0, 0, 0644, len(member.content))
+		if len(header) != 60 {
+			t.Fatalf("ar header is not 60 bytes long, got %d bytes", len(header))
+		}
+		archive.WriteString(header)
+		archive.Write(member.content)
+		if len(member.content)%2 != 0 {
+			archive.WriteByte('\n')
+		}
+	}
+	return archive.Bytes()
+}
+
+// buildTarball creates a tarball holding one regular file per name, compressed
+// according to the suffix of memberName.
+func buildTarball(t *testing.T, memberName string, files map[string]string) []byte {
+	buffer := new(bytes.Buffer)
+	tarWriter := tar.NewWriter(buffer)
+	for name, content := range files {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return compressForTest(t, buffer.Bytes(), memberName)
+}
+
+func validDebMembers(t *testing.T) []arMember {
+	return []arMember{
+		{name: "debian-binary", content: []byte("2.0\n")},
+		{name: "control.tar.xz", content: buildTarball(t, "control.tar.xz", map[string]string{"./control": "Package: testpkg\n"})},
+		{name: "data.tar.zst", content: buildTarball(t, "data.tar.zst", map[string]string{"./usr/bin/testpkg": "binary"})},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := &MockFile{Reader: bytes.NewReader(buildArchive(t, validDebMembers(t)...))}
+
+	if err := Validate(file); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The file must be rewound for the extractor
+	position, _ := file.Seek(0, 1)
+	if position != 0 {
+		t.Errorf("expected file to be rewound, position is %d", position)
+	}
+}
+
+func TestValidateControlWithoutDotSlash(t *testing.T) {
+	members := validDebMembers(t)
+	members[1] = arMember{name: "control.tar.gz", content: buildTarball(t, "control.tar.gz", map[string]string{"control": "Package: testpkg\n"})}
+	file := &MockFile{Reader: bytes.NewReader(buildArchive(t, members...))}
+
+	if err := Validate(file); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestValidateExtensionMember(t *testing.T) {
+	members := validDebMembers(t)
+	members = append(members[:1], append([]arMember{{name: "_gpgorigin", content: []byte("signature")}}, members[1:]...)...)
+	file := &MockFile{Reader: bytes.NewReader(buildArchive(t, members...))}
+
+	if err := Validate(file); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	valid := buildArchive(t, validDebMembers(t)...)
+
+	tests := []struct {
+		name          string
+		archive       func() []byte
+		expectedError string
+	}{
+		{
+			name:          "not an ar archive",
+			archive:       func() []byte { return []byte("this is not a valid .deb file") },
+			expectedError: "not an ar archive",
+		},
+		{
+			name: "debian-binary not first",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				return buildArchive(t, members[1], members[0], members[2])
+			},
+			expectedError: "first member is control.tar.xz, expected debian-binary",
+		},
+		{
+			name: "wrong format version",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				members[0].content = []byte("3.0\n")
+				return buildArchive(t, members...)
+			},
+			expectedError: `unsupported format version "3.0" in debian-binary`,
+		},
+		{
+			name: "data before control",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				return buildArchive(t, members[0], members[2], members[1])
+			},
+			expectedError: "expected control archive after debian-binary, found data.tar.zst",
+		},
+		{
+			name: "missing data archive",
+			archive: func() []byte {
+				return buildArchive(t, validDebMembers(t)[:2]...)
+			},
+			expectedError: "missing data archive",
+		},
+		{
+			name: "unknown data compression",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				members[2].name = "data.tar.lz4"
+				return buildArchive(t, members...)
+			},
+			expectedError: "expected data archive after control archive, found data.tar.lz4",
+		},
+		{
+			name: "control archive without control file",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				members[1].content = buildTarball(t, "control.tar.xz", map[string]string{"./postinst": "#!/bin/sh\n"})
+				return buildArchive(t, members...)
+			},
+			expectedError: "control.tar.xz does not contain a control file",
+		},
+		{
+			name: "corrupt control archive",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				members[1].content = []byte("not xz data")
+				return buildArchive(t, members...)
+			},
+			expectedError: "failed to decompress control.tar.xz",
+		},
+		{
+			name: "truncated data archive",
+			archive: func() []byte {
+				members := validDebMembers(t)
+				members[2] = arMember{name: "data.tar", content: buildTarball(t, "data.tar", map[string]string{"./usr/bin/testpkg": "binary"})}
+				archive := buildArchive(t, members...)
+				return archive[:len(archive)-10]
+			},
+			expectedError: "data.tar is truncated",
+		},
+		{
+			name:          "corrupt compressed data archive",
+			archive:       func() []byte { return valid[:len(valid)-10] },
+			expectedError: "failed to read data.tar.zst",
+		},
+		{
+			name:          "truncated member header",
+			archive:       func() []byte { return valid[:8+60+4+30] },
+			expectedError: "truncated ar member header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &MockFile{Reader: bytes.NewReader(tt.archive())}
+
+			err := Validate(file)
+			if !errors.Is(err, ErrInvalidPackage) {
+				t.Fatalf("expected ErrInvalidPackage, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected error containing %q, got %q", tt.expectedError, err)
+			}
+
+			// A stream is checked the same way
+			err = ValidateReader(bytes.NewReader(tt.archive()))
+			if !errors.Is(err, ErrInvalidPackage) || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected ErrInvalidPackage containing %q from a stream, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package deb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidVersion is returned by ParseVersion for versions dpkg would refuse.
+var ErrInvalidVersion = errors.New("invalid version")
+
+// Version is a Debian package version, [epoch:]upstream_version[-debian_revision].
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+// ParseVersion parses a Debian version with the rules of dpkg. The epoch is the
+// number before the first colon and the revision is what follows the last hyphen;
+// both are optional. The upstream version must start with a digit and may only
+// contain alphanumerics and ".+~-:", where "-" and ":" need a revision and an epoch.
+// The revision may only contain alphanumerics and ".+~". Leading and trailing
+// spaces are ignored.
+func ParseVersion(version string) (Version, error) {
+	text := strings.TrimSpace(version)
+	if text == "" {
+		return Version{}, fmt.Errorf("%w: version string is empty", ErrInvalidVersion)
+	}
+	if strings.ContainsAny(text, " \t\n") {
+		return Version{}, fmt.Errorf("%w %q: version string has embedded spaces", ErrInvalidVersion, version)
+	}
+
+	var v Version
+	if epoch, rest, found := strings.Cut(text, ":"); found {
+		if epoch == "" {
+			return Version{}, fmt.Errorf("%w %q: epoch is empty", ErrInvalidVersion, version)
+		}
+		if strings.TrimLeft(epoch, "0123456789") != "" {
+			return Version{}, fmt.Errorf("%w %q: epoch is not a number", ErrInvalidVersion, version)
+		}
+		number, err := strconv.ParseInt(epoch, 10, 32)
+		if err != nil {
+			return Version{}, fmt.Errorf("%w %q: epoch is too big", ErrInvalidVersion, version)
+		}
+		if rest == "" {
+			return Version{}, fmt.Errorf("%w %q: nothing after the colon", ErrInvalidVersion, version)
+		}
+		v.Epoch, text = int(number), rest
+	}
+
+	v.Upstream = text
+	if i := strings.LastIndexByte(text, '-'); i >= 0 {
+		v.Upstream, v.Revision = text[:i], text[i+1:]
+		if v.Revision == "" {
+			return Version{}, fmt.Errorf("%w %q: revision is empty", ErrInvalidVersion, version)
+		}
+	}
+
+	if v.Upstream == "" {
+		return Version{}, fmt.Errorf("%w %q: upstream version is empty", ErrInvalidVersion, version)
+	}
+	if !isDigit(v.Upstream[0]) {
+		return Version{}, fmt.Errorf("%w %q: upstream version does not start with a digit", ErrInvalidVersion, version)
+	}
+	if !validVersionPart(v.Upstream, ".+~-:") {
+		return Version{}, fmt.Errorf("%w %q: invalid character in upstream version", ErrInvalidVersion, version)
+	}
+	if !validVersionPart(v.Revision, ".+~") {
+		return Version{}, fmt.Errorf("%w %q: invalid character in revision", ErrInvalidVersion, version)
+	}
+
+	return v, nil
+}
+
+// String returns the version in its [epoch:]upstream_version[-debian_revision]
+// form. A zero epoch is left out.
+func (v Version) String() string {
+	var sb strings.Builder
+	if v.Epoch != 0 {
+		sb.WriteString(strconv.Itoa(v.Epoch))
+		sb.WriteByte(':')
+	}
+	sb.WriteString(v.Upstream)
+	if v.Revision != "" {
+		sb.WriteByte('-')
+		sb.WriteString(v.Revision)
+	}
+	return sb.String()
+}
+
+// Compare orders versions the way dpkg does. It returns a negative number, zero or
+// a positive number when v sorts before, equal to or after other. Versions such as
+// 1.0 and 0:1.0-0 are equal.
+func (v Version) Compare(other Version) int {
+	if v.Epoch != other.Epoch {
+		if v.Epoch < other.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareVersionPart(v.Upstream, other.Upstream); c != 0 {
+		return c
+	}
+	return compareVersionPart(v.Revision, other.Revision)
+}
+
+// CompareVersions compares two version strings like Version.Compare. Versions that
+// ParseVersion rejects are compared too, split the same way, with an epoch that is
+// not a number counting as 0.
+func CompareVersions(a, b string) int {
+	return splitVersion(a).Compare(splitVersion(b))
+}
+
+// Relation is a dpkg version relation, as written in a Depends field.
+type Relation string
+
+const (
+	RelationEarlier      Relation = "<<"
+	RelationEarlierEqual Relation = "<="
+	RelationEqual        Relation = "="
+	RelationLaterEqual   Relation = ">="
+	RelationLater        Relation = ">>"
+)
+
+// relations lists the relations longest operator first, so that "<<" is not read as "<".
+var relations = []Relation{RelationEarlier, RelationEarlierEqual, RelationLaterEqual, RelationLater, RelationEqual}
+
+// CutRelation splits text that starts with a relation operator into the relation
+// and the rest of text. It reports false when text does not start with one. The
+// obsolete "<" and ">", which dpkg reads as "<=" and ">=", are not accepted.
+func CutRelation(text string) (Relation, string, bool) {
+	for _, relation := range relations {
+		if rest, found := strings.CutPrefix(text, string(relation)); found {
+			return relation, rest, true
+		}
+	}
+	return "", text, false
+}
+
+// Satisfies reports whether version stands in the relation to reference, comparing
+// them like CompareVersions.
+func (r Relation) Satisfies(version, reference string) bool {
+	c := CompareVersions(version, reference)
+	switch r {
+	case RelationEarlier:
+		return c < 0
+	case RelationEarlierEqual:
+		return c <= 0
+	case RelationEqual:
+		return c == 0
+	case RelationLaterEqual:
+		return c >= 0
+	case RelationLater:
+		return c > 0
+	default:
+		return false
+	}
+}
+
+// splitVersion splits a version into its parts without validating them.
+func splitVersion(version string) Version {
+	var v Version
+	if epoch, rest, found := strings.Cut(version, ":"); found {
+		v.Epoch, _ = strconv.Atoi(epoch)
+		version = rest
+	}
+
+	v.Upstream = version
+	if i := strings.LastIndexByte(version, '-'); i >= 0 {
+		v.Upstream, v.Revision = version[:i], version[i+1:]
+	}
+	return v
+}
+
+// validVersionPart reports whether part only holds alphanumerics and the given
+// punctuation.
+func validVersionPart(part, punctuation string) bool {
+	for i := 0; i < len(part); i++ {
+		c := part[i]
+		if !isDigit(c) && !isLetter(c) && !strings.ContainsRune(punctuation, rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersionPart compares upstream versions or revisions with the algorithm of
+// dpkg's verrevcmp: alternating non-digit parts, compared character by character
+// with letters sorting before other characters and "~" before everything, even the
+// end of the part, and digit parts, compared numerically.
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			if c := versionCharOrder(a) - versionCharOrder(b); c != 0 {
+				return c
+			}
+			a, b = advance(a), advance(b)
+		}
+
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		firstDiff := 0
+		for a != "" && isDigit(a[0]) && b != "" && isDigit(b[0]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[0]) - int(b[0])
+			}
+			a, b = a[1:], b[1:]
+		}
+		if a != "" && isDigit(a[0]) {
+			return 1
+		}
+		if b != "" && isDigit(b[0]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+// versionCharOrder returns the sort weight of the first character of s within a
+// non-digit part. The end of the part and a digit weigh 0.
+func versionCharOrder(s string) int {
+	if s == "" {
+		return 0
+	}
+	c := s[0]
+	switch {
+	case isDigit(c):
+		return 0
+	case isLetter(c):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// advance drops the first character of s, if any.
+func advance(s string) string {
+	if s == "" {
+		return s
+	}
+	return s[1:]
+}
@@ -0,0 +1,237 @@
+package deb
+
+import (
+	"errors"
+	"testing"
+)
+
+// Comparison vectors from dpkg's lib/dpkg/t/t-version.c and the version test list
+// shared by apt and cupt.
+var versionComparisons = []struct {
+	a, b     string
+	expected int
+}{
+	// Equality
+	{"0:0-0", "0:0-0", 0},
+	{"0:0-00", "0:00-0", 0},
+	{"1:2-3", "1:2-3", 0},
+	{"0", "0", 0},
+	{"0", "00", 0},
+	{"1.2.3", "1.2.3", 0},
+	{"4.4.3-2", "4.4.3-2", 0},
+	{"1:2ab:5", "1:2ab:5", 0},
+	{"7:1-a:b-5", "7:1-a:b-5", 0},
+	{"57:1.2.3abYZ+~-4-5", "57:1.2.3abYZ+~-4-5", 0},
+	{"1.2.3", "0:1.2.3", 0},
+	{"1.2.3", "1.2.3-0", 0},
+	{"009", "9", 0},
+	{"009ab5", "9ab5", 0},
+	{"0:1.18.36", "1.18.36", 0},
+	{"1.0", "1.0-0", 0},
+
+	// Epochs
+	{"0:0-0", "1:0-0", -1},
+	{"1:0.4", "10.3", 1},
+	{"1:1.25-4", "1:1.25-8", -1},
+	{"1:1.2.3", "1.2.4", 1},
+	{"1:1.2.3", "1:1.2.4", -1},
+	{"5:2", "304-2", 1},
+	{"5:2", "304:2", -1},
+	{"25:2", "3:2", 1},
+	{"1:2:123", "1:12:4", -1},
+	{"2:0.9", "1.5", 1},
+
+	// Upstream versions
+	{"0:a-0", "0:b-0", -1},
+	{"1:a-0", "1:b-0", -1},
+	{"7.6p2-4", "7.6-0", 1},
+	{"1.0.3-3", "1.0-1", 1},
+	{"1.3", "1.2.2-2", 1},
+	{"1.3", "1.2.2", 1},
+	{"1.2.3", "1.2.4", -1},
+	{"1.2.4", "1.2.3", 1},
+	{"1.2.24", "1.2.3", 1},
+	{"0.10.0", "0.8.7", 1},
+	{"3.2", "2.3", 1},
+	{"1.3.2a", "1.3.2", 1},
+	{"2a", "21", -1},
+	{"1.3.2a", "1.3.2b", -1},
+	{"5.10.0", "5.005", 1},
+	{"3a9.8", "3.10.2", -1},
+	{"1.002-1+b2", "1.00", 1},
+	{"9:1.18.36:5.4-20", "10:0.5.1-22", -1},
+	{"9:1.18.36:5.4-20", "9:1.18.36:5.5-1", -1},
+	{"9:1.18.36:5.4-20", "9:1.18.37:4.3-22", -1},
+	{"1.18.36-0.17.35-18", "1.18.36-19", 1},
+	{"0:0-0-0", "0-0", 1},
+	{"1.2-5", "1.2-3-5", -1},
+	{"0.2", "1.0-0", -1},
+	{"2.0.7pre1-4", "2.0.7r-1", -1},
+
+	// Tilde sorts before everything, even the end of the version
+	{"1.0~rc1", "1.0", -1},
+	{"3.0~rc1-1", "3.0-1", -1},
+	{"0.5.0~git", "0.5.0~git2", -1},
+	{"1.2a+~bCd3", "1.2a++", -1},
+	{"1.2a+~bCd3", "1.2a+~", 1},
+	{"3a9.8", "3~10", 1},
+	{"1.4+OOo3.0.0~", "1.4+OOo3.0.0-4", -1},
+	{"1.0~~", "1.0~", -1},
+	{"1.0~~a", "1.0~~", 1},
+	{"1.0", "1.0-0~", 1},
+
+	// Revisions
+	{"0:0-a", "0:0-b", -1},
+	{"1:0-a", "1:0-b", -1},
+	{"1:1.2.13-3", "1:1.2.13-3.1", -1},
+	{"1.2.3", "1.2.3-1", -1},
+	{"1.0", "1.0-0+b1", -1},
+	{"2.4.7-1", "2.4.7-z", -1},
+
+	// Letters sort before other punctuation
+	{"1.0a", "1.0+", -1},
+	{"1.0a", "1.0.", -1},
+	{"1.0+", "1.0.", -1},
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range versionComparisons {
+		if result := sign(CompareVersions(tt.a, tt.b)); result != tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", tt.a, tt.b, result, tt.expected)
+		}
+		if result := sign(CompareVersions(tt.b, tt.a)); result != -tt.expected {
+			t.Errorf("CompareVersions(%q, %q) = %d, expected %d", tt.b, tt.a, result, -tt.expected)
+		}
+
+		a, errA := ParseVersion(tt.a)
+		b, errB := ParseVersion(tt.b)
+		if errA != nil || errB != nil {
+			continue
+		}
+		if result := sign(a.Compare(b)); result != tt.expected {
+			t.Errorf("%q.Compare(%q) = %d, expected %d", tt.a, tt.b, result, tt.expected)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected Version
+		str      string
+	}{
+		{version: "0", expected: Version{Upstream: "0"}, str: "0"},
+		{version: "0:0", expected: Version{Upstream: "0"}, str: "0"},
+		{version: "0:0-0", expected: Version{Upstream: "0", Revision: "0"}, str: "0-0"},
+		{version: "0:0.0-0.0", expected: Version{Upstream: "0.0", Revision: "0.0"}, str: "0.0-0.0"},
+		{version: "1:2.30-4", expected: Version{Epoch: 1, Upstream: "2.30", Revision: "4"}, str: "1:2.30-4"},
+		{version: "0:0-0-0", expected: Version{Upstream: "0-0", Revision: "0"}, str: "0-0-0"},
+		{version: "0:0:0-0", expected: Version{Upstream: "0:0", Revision: "0"}, str: "0:0-0"},
+		{version: "0:0:0:0-0", expected: Version{Upstream: "0:0:0", Revision: "0"}, str: "0:0:0-0"},
+		{version: "0:0:0-0-0", expected: Version{Upstream: "0:0-0", Revision: "0"}, str: "0:0-0-0"},
+		{version: "0:0-0:0-0", expected: Version{Upstream: "0-0:0", Revision: "0"}, str: "0-0:0-0"},
+		{version: "0:09azAZ.-+~:-0", expected: Version{Upstream: "09azAZ.-+~:", Revision: "0"}, str: "09azAZ.-+~:-0"},
+		{version: "0:0-azAZ09.+~", expected: Version{Upstream: "0", Revision: "azAZ09.+~"}, str: "0-azAZ09.+~"},
+		{version: "  0:0-1", expected: Version{Upstream: "0", Revision: "1"}, str: "0-1"},
+		{version: "0:0-1\t ", expected: Version{Upstream: "0", Revision: "1"}, str: "0-1"},
+		{version: "1.0~rc1+dfsg-2ubuntu0.1", expected: Version{Upstream: "1.0~rc1+dfsg", Revision: "2ubuntu0.1"}, str: "1.0~rc1+dfsg-2ubuntu0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			version, err := ParseVersion(tt.version)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if version != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, version)
+			}
+			if version.String() != tt.str {
+				t.Errorf("expected %q, got %q", tt.str, version.String())
+			}
+		})
+	}
+}
+
+func TestParseVersionInvalid(t *testing.T) {
+	versions := []string{
+		"",
+		"  ",
+		"0:",
+		":1.0",
+		"1.0-",
+		"0:0 0-1",
+		"-1:0-1",
+		"999999999999999999999999:0-1",
+		"a:0-0",
+		"A:0-0",
+		"0:abc3-0",
+		"0:0-0:0",
+		"0:0-",
+	}
+	for _, c := range "!#@$%&/|\\<>()[]{};,_=*^'" {
+		versions = append(versions, "0:0"+string(c)+"-0", "0:0-"+string(c))
+	}
+
+	for _, version := range versions {
+		if _, err := ParseVersion(version); !errors.Is(err, ErrInvalidVersion) {
+			t.Errorf("expected ParseVersion(%q) to fail with ErrInvalidVersion, got %v", version, err)
+		}
+	}
+}
+
+// sign returns -1, 0 or 1 for a comparison result.
+func sign(c int) int {
+	switch {
+	case c < 0:
+		return -1
+	case c > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func TestRelationSatisfies(t *testing.T) {
+	tests := []struct {
+		relation Relation
+		version  string
+		expected bool
+	}{
+		{RelationEarlier, "1.0~rc1", true},
+		{RelationEarlier, "1.0", false},
+		{RelationEarlier, "1.0.1", false},
+		{RelationEarlierEqual, "1.0~rc1", true},
+		{RelationEarlierEqual, "0:1.0-0", true},
+		{RelationEarlierEqual, "1.0.1", false},
+		{RelationEqual, "1.0", true},
+		{RelationEqual, "0:1.0", true},
+		{RelationEqual, "1.0-1", false},
+		{RelationLaterEqual, "1.0", true},
+		{RelationLaterEqual, "1.0+b1", true},
+		{RelationLaterEqual, "1.0~rc1", false},
+		{RelationLater, "1.0+b1", true},
+		{RelationLater, "1:0.9", true},
+		{RelationLater, "1.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.relation.Satisfies(tt.version, "1.0"); got != tt.expected {
+			t.Errorf("%s %s 1.0: expected %v, got %v", tt.version, tt.relation, tt.expected, got)
+		}
+	}
+}
+
+func TestCutRelation(t *testing.T) {
+	for _, operator := range []string{"<<", "<=", "=", ">=", ">>"} {
+		relation, rest, ok := CutRelation(operator + "2.0")
+		if !ok || string(relation) != operator || rest != "2.0" {
+			t.Errorf("CutRelation(%q) = %q, %q, %v", operator+"2.0", relation, rest, ok)
+		}
+	}
+	for _, text := range []string{"<2.0", ">2.0", "!=2.0", "2.0", ""} {
+		if _, _, ok := CutRelation(text); ok {
+			t.Errorf("CutRelation(%q) found a relation", text)
+		}
+	}
+}
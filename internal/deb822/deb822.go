@@ -0,0 +1,179 @@
+// Package deb822 parses and serializes the RFC 822-like format used by Debian
+// control files, Packages indices and Release files.
+//
+// A file consists of paragraphs separated by blank lines. Each paragraph is an
+// ordered list of fields. A field value may span several lines: continuation
+// lines start with a space or tab, and a continuation line holding a single "."
+// stands for an empty line within the value. Lines starting with "#" are comments
+// and are ignored.
+package deb822
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a single field of a paragraph.
+//
+// Value holds the text after the colon with surrounding whitespace removed. For
+// multi-line fields every continuation line is appended after a newline with
+// its leading space or tab removed, so the "." separator line is kept as ".".
+// A value whose first line is empty, such as a Release checksum list, starts
+// with a newline.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Paragraph is an ordered list of fields, also known as a stanza.
+type Paragraph struct {
+	Fields []Field
+}
+
+// Get returns the value of the named field, or an empty string if it is absent.
+// Field names are matched case-insensitively.
+func (p *Paragraph) Get(name string) string {
+	if i := p.index(name); i >= 0 {
+		return p.Fields[i].Value
+	}
+	return ""
+}
+
+// Has reports whether the paragraph contains the named field.
+func (p *Paragraph) Has(name string) bool {
+	return p.index(name) >= 0
+}
+
+// Set replaces the value of the named field in place, or appends the field if
+// the paragraph does not contain it yet.
+func (p *Paragraph) Set(name, value string) {
+	if i := p.index(name); i >= 0 {
+		p.Fields[i].Value = value
+		return
+	}
+	p.Fields = append(p.Fields, Field{Name: name, Value: value})
+}
+
+// Delete removes the named field from the paragraph.
+func (p *Paragraph) Delete(name string) {
+	if i := p.index(name); i >= 0 {
+		p.Fields = append(p.Fields[:i], p.Fields[i+1:]...)
+	}
+}
+
+// String serializes the paragraph, ending with a newline.
+func (p *Paragraph) String() string {
+	var sb strings.Builder
+	for _, field := range p.Fields {
+		writeField(&sb, field)
+	}
+	return sb.String()
+}
+
+func (p *Paragraph) index(name string) int {
+	for i, field := range p.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeField writes a field with its continuation lines. Empty continuation
+// lines are written as " ." so the value stays in a single paragraph.
+func writeField(sb *strings.Builder, field Field) {
+	lines := strings.Split(field.Value, "\n")
+
+	sb.WriteString(field.Name)
+	sb.WriteString(":")
+	if lines[0] != "" {
+		sb.WriteString(" ")
+		sb.WriteString(lines[0])
+	}
+	sb.WriteString("\n")
+
+	for _, line := range lines[1:] {
+		if line == "" {
+			line = "."
+		}
+		sb.WriteString(" ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+}
+
+// Parse reads every paragraph from r.
+func Parse(r io.Reader) ([]Paragraph, error) {
+	var paragraphs []Paragraph
+	var current *Paragraph
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// Blank lines end the current paragraph
+		if strings.TrimSpace(line) == "" {
+			if current != nil {
+				paragraphs = append(paragraphs, *current)
+				current = nil
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Continuation lines extend the value of the previous field
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == nil || len(current.Fields) == 0 {
+				return nil, fmt.Errorf("line %d: continuation line outside of a field", lineNumber)
+			}
+			last := &current.Fields[len(current.Fields)-1]
+			last.Value += "\n" + strings.TrimRight(line[1:], " \t")
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("line %d: invalid field: %s", lineNumber, line)
+		}
+
+		if current == nil {
+			current = &Paragraph{}
+		}
+		if current.Has(name) {
+			return nil, fmt.Errorf("line %d: duplicate field %s", lineNumber, name)
+		}
+		current.Fields = append(current.Fields, Field{Name: name, Value: strings.TrimSpace(value)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read paragraphs: %w", err)
+	}
+
+	if current != nil {
+		paragraphs = append(paragraphs, *current)
+	}
+	return paragraphs, nil
+}
+
+// ParseString reads every paragraph from text.
+func ParseString(text string) ([]Paragraph, error) {
+	return Parse(strings.NewReader(text))
+}
+
+// Format serializes paragraphs, separating them with blank lines.
+func Format(paragraphs []Paragraph) string {
+	texts := make([]string, 0, len(paragraphs))
+	for _, paragraph := range paragraphs {
+		texts = append(texts, paragraph.String())
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,171 @@
+package deb822
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	text := `# Leading comment
+Package: testpkg
+Version: 1.0
+Description: Test package
+ This is the long description.
+ .
+ It has two paragraphs.
+X-Custom-Field:   padded value
+
+Package: other
+# Comment inside a paragraph
+Depends: libc6,
+ libssl3
+`
+
+	paragraphs, err := ParseString(text)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(paragraphs) != 2 {
+		t.Fatalf("expected 2 paragraphs, got %d", len(paragraphs))
+	}
+
+	first := paragraphs[0]
+	expectedNames := []string{"Package", "Version", "Description", "X-Custom-Field"}
+	if len(first.Fields) != len(expectedNames) {
+		t.Fatalf("expected %d fields, got %d", len(expectedNames), len(first.Fields))
+	}
+	for i, name := range expectedNames {
+		if first.Fields[i].Name != name {
+			t.Errorf("expected field %d to be %s, got %s", i, name, first.Fields[i].Name)
+		}
+	}
+
+	expectedDescription := "Test package\nThis is the long description.\n.\nIt has two paragraphs."
+	if first.Get("Description") != expectedDescription {
+		t.Errorf("expected description %q, got %q", expectedDescription, first.Get("Description"))
+	}
+	if first.Get("x-custom-field") != "padded value" {
+		t.Errorf("expected case-insensitive lookup to return 'padded value', got %q", first.Get("x-custom-field"))
+	}
+	if paragraphs[1].Get("Depends") != "libc6,\nlibssl3" {
+		t.Errorf("expected multi-line Depends, got %q", paragraphs[1].Get("Depends"))
+	}
+}
+
+func TestParseEmptyFirstLine(t *testing.T) {
+	text := "SHA256:\n abc 10 main/binary-amd64/Packages\n def 20 main/binary-amd64/Packages.gz\n"
+
+	paragraphs, err := ParseString(text)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := "\nabc 10 main/binary-amd64/Packages\ndef 20 main/binary-amd64/Packages.gz"
+	if paragraphs[0].Get("SHA256") != expected {
+		t.Errorf("expected %q, got %q", expected, paragraphs[0].Get("SHA256"))
+	}
+	if paragraphs[0].String() != text {
+		t.Errorf("expected:\n%s\ngot:\n%s", text, paragraphs[0].String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		expectedError string
+	}{
+		{
+			name:          "continuation without field",
+			text:          " dangling\n",
+			expectedError: "line 1: continuation line outside of a field",
+		},
+		{
+			name:          "line without colon",
+			text:          "Package: testpkg\nnot a field\n",
+			expectedError: "line 2: invalid field: not a field",
+		},
+		{
+			name:          "empty field name",
+			text:          ": value\n",
+			expectedError: "line 1: invalid field",
+		},
+		{
+			name:          "duplicate field",
+			text:          "Package: a\npackage: b\n",
+			expectedError: "line 2: duplicate field package",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseString(tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	text := `Package: testpkg
+Version: 1:2.0-1
+Description: Test package
+ Long description.
+ .
+   Indented verbatim line.
+Empty:
+
+Package: other
+Version: 1.0
+`
+
+	paragraphs, err := ParseString(text)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result := Format(paragraphs); result != text {
+		t.Errorf("expected:\n%s\ngot:\n%s", text, result)
+	}
+}
+
+func TestParagraphEditing(t *testing.T) {
+	var p Paragraph
+	p.Set("Package", "testpkg")
+	p.Set("Version", "1.0")
+	p.Set("Description", "Short\nLong line\n\nSecond paragraph")
+	p.Set("version", "2.0")
+
+	// Set keeps the position and name of an existing field
+	expected := `Package: testpkg
+Version: 2.0
+Description: Short
+ Long line
+ .
+ Second paragraph
+`
+	if p.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, p.String())
+	}
+
+	p.Delete("VERSION")
+	if p.Has("Version") {
+		t.Errorf("expected Version to be deleted")
+	}
+	if len(p.Fields) != 2 {
+		t.Errorf("expected 2 fields after delete, got %d", len(p.Fields))
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	paragraphs, err := ParseString("Package: testpkg\r\nVersion: 1.0\r\n\r\nPackage: other\r\n")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(paragraphs) != 2 {
+		t.Fatalf("expected 2 paragraphs, got %d", len(paragraphs))
+	}
+	if paragraphs[0].Get("Version") != "1.0" {
+		t.Errorf("expected version '1.0', got %q", paragraphs[0].Get("Version"))
+	}
+}
@@ -0,0 +1,124 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+// Signer produces the OpenPGP signatures apt expects next to a Release file.
+type Signer interface {
+	// ClearSign returns the clearsigned message used as InRelease.
+	ClearSign(data []byte) ([]byte, error)
+	// DetachSign returns the armored detached signature used as Release.gpg.
+	DetachSign(data []byte) ([]byte, error)
+}
+
+// Config describes where the armored private key comes from. KeyFile takes
+// precedence over Key, which holds the armored key itself.
+type Config struct {
+	KeyFile    string
+	Key        string
+	Passphrase string
+}
+
+type signerImpl struct {
+	logger *log.Entry
+	entity *openpgp.Entity
+}
+
+// New loads the private key described by config and returns a Signer using it.
+func New(logger *log.Entry, config *Config) (Signer, error) {
+	armoredKey := config.Key
+	if config.KeyFile != "" {
+		keyData, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GPG key file: %w", err)
+		}
+		armoredKey = string(keyData)
+	}
+	if armoredKey == "" {
+		return nil, fmt.Errorf("no GPG key provided")
+	}
+
+	keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG key: %w", err)
+	}
+
+	// Use the first entity that carries a private key
+	var entity *openpgp.Entity
+	for _, candidate := range keyRing {
+		if candidate.PrivateKey != nil {
+			entity = candidate
+			break
+		}
+	}
+	if entity == nil {
+		return nil, fmt.Errorf("GPG key does not contain a private key")
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if config.Passphrase == "" {
+			return nil, fmt.Errorf("GPG key is protected by a passphrase but none was provided")
+		}
+		if err := entity.DecryptPrivateKeys([]byte(config.Passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt GPG key: %w", err)
+		}
+	}
+
+	logger.Debugf("Loaded GPG signing key %s", entity.PrimaryKey.KeyIdString())
+	return &signerImpl{
+		logger: logger,
+		entity: entity,
+	}, nil
+}
+
+// Initialize creates a Signer and exits if the key cannot be loaded.
+func Initialize(logger *log.Entry, config *Config) Signer {
+	s, err := New(logger, config)
+	if err != nil {
+		logger.Fatalf("Failed to initialize GPG signer: %v", err)
+	}
+	return s
+}
+
+// ClearSign returns data as a clearsigned message.
+func (s *signerImpl) ClearSign(data []byte) ([]byte, error) {
+	signingKey, ok := s.entity.SigningKey(time.Now())
+	if !ok {
+		return nil, fmt.Errorf("GPG key has no valid signing key")
+	}
+
+	var signed bytes.Buffer
+	plaintext, err := clearsign.Encode(&signed, signingKey.PrivateKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start clearsigning: %w", err)
+	}
+	if _, err := plaintext.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to clearsign data: %w", err)
+	}
+	if err := plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish clearsigning: %w", err)
+	}
+
+	s.logger.Debug("Created clearsigned message")
+	return signed.Bytes(), nil
+}
+
+// DetachSign returns an armored detached signature over data.
+func (s *signerImpl) DetachSign(data []byte) ([]byte, error) {
+	var signature bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&signature, s.entity, bytes.NewReader(data), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create detached signature: %w", err)
+	}
+
+	s.logger.Debug("Created detached signature")
+	return signature.Bytes(), nil
+}
@@ -0,0 +1,100 @@
+package signer
+
+import (
+	"bytes"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const releaseContent = "Origin: Test\nLabel: Test\nSuite: stable\n"
+
+// generateArmoredKey creates a fresh key pair and returns the armored private key
+// and the entity used to verify signatures.
+func generateArmoredKey(t *testing.T, passphrase string) (string, openpgp.EntityList) {
+	entity, err := openpgp.NewEntity("Test Repo", "", "repo@example.com", nil)
+	require.NoError(t, err)
+
+	if passphrase != "" {
+		require.NoError(t, entity.EncryptPrivateKeys([]byte(passphrase), nil))
+	}
+
+	var armored bytes.Buffer
+	writer, err := armor.Encode(&armored, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivateWithoutSigning(writer, nil))
+	require.NoError(t, writer.Close())
+
+	return armored.String(), openpgp.EntityList{entity}
+}
+
+func newTestLogger() *log.Entry {
+	return log.NewEntry(log.New())
+}
+
+func TestClearSign(t *testing.T) {
+	armoredKey, keyRing := generateArmoredKey(t, "")
+	s, err := New(newTestLogger(), &Config{Key: armoredKey})
+	require.NoError(t, err)
+
+	signed, err := s.ClearSign([]byte(releaseContent))
+	require.NoError(t, err)
+
+	block, _ := clearsign.Decode(signed)
+	require.NotNil(t, block)
+	assert.Equal(t, releaseContent, string(block.Plaintext))
+	_, err = block.VerifySignature(keyRing, nil)
+	assert.NoError(t, err)
+}
+
+func TestDetachSign(t *testing.T) {
+	armoredKey, keyRing := generateArmoredKey(t, "")
+	s, err := New(newTestLogger(), &Config{Key: armoredKey})
+	require.NoError(t, err)
+
+	signature, err := s.DetachSign([]byte(releaseContent))
+	require.NoError(t, err)
+	assert.Contains(t, string(signature), "-----BEGIN PGP SIGNATURE-----")
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyRing, bytes.NewReader([]byte(releaseContent)), bytes.NewReader(signature), nil)
+	assert.NoError(t, err)
+}
+
+func TestNewWithPassphrase(t *testing.T) {
+	armoredKey, keyRing := generateArmoredKey(t, "secret")
+
+	_, err := New(newTestLogger(), &Config{Key: armoredKey})
+	assert.ErrorContains(t, err, "protected by a passphrase")
+
+	_, err = New(newTestLogger(), &Config{Key: armoredKey, Passphrase: "wrong"})
+	assert.ErrorContains(t, err, "failed to decrypt GPG key")
+
+	s, err := New(newTestLogger(), &Config{Key: armoredKey, Passphrase: "secret"})
+	require.NoError(t, err)
+
+	signature, err := s.DetachSign([]byte(releaseContent))
+	require.NoError(t, err)
+	_, err = openpgp.CheckArmoredDetachedSignature(keyRing, bytes.NewReader([]byte(releaseContent)), bytes.NewReader(signature), nil)
+	assert.NoError(t, err)
+}
+
+func TestNewFromKeyFile(t *testing.T) {
+	armoredKey, _ := generateArmoredKey(t, "")
+	keyFile := filepath.Join(t.TempDir(), "key.asc")
+	require.NoError(t, os.WriteFile(keyFile, []byte(armoredKey), 0600))
+
+	_, err := New(newTestLogger(), &Config{KeyFile: keyFile})
+	assert.NoError(t, err)
+
+	_, err = New(newTestLogger(), &Config{KeyFile: filepath.Join(t.TempDir(), "missing.asc")})
+	assert.ErrorContains(t, err, "failed to read GPG key file")
+
+	_, err = New(newTestLogger(), &Config{})
+	assert.ErrorContains(t, err, "no GPG key provided")
+}
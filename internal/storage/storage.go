@@ -8,17 +8,26 @@ import (
 	"github.com/pavliha/aptforge/internal/filereader"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"time"
 )
 
 import "errors"
 
 var ErrNotFound = errors.New("object not found")
 
+// ErrPreconditionFailed is returned by UploadBufferIfMatch when the object was changed
+// or created since its ETag was read.
+var ErrPreconditionFailed = errors.New("object was modified concurrently")
+
 type Storage interface {
 	UploadFile(ctx context.Context, s3Key string, file filereader.File) error
 	UploadBuffer(ctx context.Context, s3Key string, buffer *bytes.Buffer) error
+	UploadBufferIfMatch(ctx context.Context, s3Key string, buffer *bytes.Buffer, etag string) error
 	Download(ctx context.Context, s3Key string) (Object, error)
 	DownloadFile(ctx context.Context, s3Key string, dest *bytes.Buffer) error
+	DownloadFileWithETag(ctx context.Context, s3Key string, dest *bytes.Buffer) (string, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, s3Key string) error
 }
 
 type storageImpl struct {
@@ -30,6 +39,17 @@ type storageImpl struct {
 type MinioClient interface {
 	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (info minio.UploadInfo, err error)
 	GetObject(ctx context.Context, bucketName string, objectName string, opts minio.GetObjectOptions) (*minio.Object, error)
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+}
+
+// ObjectInfo describes an object returned by List.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// ETag can be passed to UploadBufferIfMatch to overwrite the object only if it is unchanged.
+	ETag string
 }
 
 type Object interface {
@@ -96,6 +116,28 @@ func (s *storageImpl) UploadFile(ctx context.Context, s3Key string, file filerea
 
 // UploadBuffer Uploader method to upload a buffer (for metadata files like Packages or Release)
 func (s *storageImpl) UploadBuffer(ctx context.Context, s3Key string, buffer *bytes.Buffer) error {
+	return s.putBuffer(ctx, s3Key, buffer, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+}
+
+// UploadBufferIfMatch uploads a buffer only if the object still has the given ETag,
+// as returned by DownloadFileWithETag. An empty etag means the object must not exist yet.
+// It returns ErrPreconditionFailed when the object was changed in the meantime.
+func (s *storageImpl) UploadBufferIfMatch(ctx context.Context, s3Key string, buffer *bytes.Buffer, etag string) error {
+	opts := minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	}
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+
+	return s.putBuffer(ctx, s3Key, buffer, opts)
+}
+
+func (s *storageImpl) putBuffer(ctx context.Context, s3Key string, buffer *bytes.Buffer, opts minio.PutObjectOptions) error {
 	size := int64(buffer.Len())
 
 	s.logger.Debugf("Uploading buffer to S3 at path: %s/%s", s.bucket, s3Key)
@@ -104,10 +146,12 @@ func (s *storageImpl) UploadBuffer(ctx context.Context, s3Key string, buffer *by
 	reader := bytes.NewReader(buffer.Bytes())
 
 	// Upload the buffer without consuming it
-	_, err := s.client.PutObject(ctx, s.bucket, s3Key, reader, size, minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
-	})
+	_, err := s.client.PutObject(ctx, s.bucket, s3Key, reader, size, opts)
 	if err != nil {
+		if isPreconditionFailed(err) {
+			s.logger.Warnf("%s/%s was modified concurrently", s.bucket, s3Key)
+			return fmt.Errorf("failed to upload buffer: %w", ErrPreconditionFailed)
+		}
 		s.logger.WithError(err).Error("Failed to upload buffer")
 		return fmt.Errorf("failed to upload buffer: %v", err)
 	}
@@ -131,13 +175,20 @@ func (s *storageImpl) Download(ctx context.Context, s3Key string) (Object, error
 }
 
 func (s *storageImpl) DownloadFile(ctx context.Context, s3Key string, dest *bytes.Buffer) error {
+	_, err := s.DownloadFileWithETag(ctx, s3Key, dest)
+	return err
+}
+
+// DownloadFileWithETag downloads an object into dest and returns its ETag, to be
+// passed to UploadBufferIfMatch when the object is written back.
+func (s *storageImpl) DownloadFileWithETag(ctx context.Context, s3Key string, dest *bytes.Buffer) (string, error) {
 	s.logger.Debugf("Downloading file from S3 at path: %s/%s", s.bucket, s3Key)
 
 	// Get the object from S3
 	object, err := s.client.GetObject(ctx, s.bucket, s3Key, minio.GetObjectOptions{})
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to get object from S3")
-		return fmt.Errorf("failed to get object from S3: %v", err)
+		return "", fmt.Errorf("failed to get object from S3: %v", err)
 	}
 	defer func(object *minio.Object) {
 		err := object.Close()
@@ -147,21 +198,59 @@ func (s *storageImpl) DownloadFile(ctx context.Context, s3Key string, dest *byte
 	}(object)
 
 	// Stat the object to check if it exists
-	_, err = object.Stat()
+	info, err := object.Stat()
 	if err != nil {
 		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
 			s.logger.Info("Object does not exist in S3")
-			return ErrNotFound
+			return "", ErrNotFound
 		}
-		return fmt.Errorf("failed to stat object: %v", err)
+		return "", fmt.Errorf("failed to stat object: %v", err)
 	}
 
 	// Copy the content to the destination buffer
 	_, err = io.Copy(dest, object)
 	if err != nil {
-		return fmt.Errorf("failed to read downloaded file: %v", err)
+		return "", fmt.Errorf("failed to read downloaded file: %v", err)
 	}
 
+	return info.ETag, nil
+}
+
+// List returns every object whose key starts with prefix, recursing into sub-paths.
+func (s *storageImpl) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.logger.Debugf("Listing objects in S3 under prefix: %s/%s", s.bucket, prefix)
+
+	var objects []ObjectInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			s.logger.WithError(object.Err).Error("Failed to list objects")
+			return nil, fmt.Errorf("failed to list objects: %v", object.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			ETag:         object.ETag,
+		})
+	}
+
+	return objects, nil
+}
+
+// Delete removes an object from the bucket. Deleting a missing object is not an error.
+func (s *storageImpl) Delete(ctx context.Context, s3Key string) error {
+	s.logger.Debugf("Deleting object from S3 at path: %s/%s", s.bucket, s3Key)
+
+	err := s.client.RemoveObject(ctx, s.bucket, s3Key, minio.RemoveObjectOptions{})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to delete object")
+		return fmt.Errorf("failed to delete object: %v", err)
+	}
+
+	s.logger.Infof("Object successfully deleted from %s/%s", s.bucket, s3Key)
 	return nil
 }
 
@@ -172,3 +261,15 @@ func IsNotFoundError(err error) bool {
 	errResp := minio.ToErrorResponse(err)
 	return errResp.StatusCode == 404 || errResp.Code == "NoSuchKey"
 }
+
+// IsPreconditionFailedError reports whether err is a failed If-Match or If-None-Match
+// condition of UploadBufferIfMatch.
+func IsPreconditionFailedError(err error) bool {
+	return errors.Is(err, ErrPreconditionFailed) || isPreconditionFailed(err)
+}
+
+func isPreconditionFailed(err error) bool {
+	errResp := minio.ToErrorResponse(err)
+	// S3 answers a conditional write that races with another one with ConditionalRequestConflict
+	return errResp.StatusCode == 412 || errResp.Code == "PreconditionFailed" || errResp.Code == "ConditionalRequestConflict"
+}